@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// jwks is a JSON Web Key Set as defined by RFC 7517
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// pkcs1PublicKey is the ASN.1 form of a PKCS #1 RSA public key
+type pkcs1PublicKey struct {
+	N *big.Int
+	E int
+}
+
+// KeySet holds the public keys which tokens may be signed with. Keys from a
+// JWKS file are selected by the kid header of a token, the key from a PEM
+// file verifies tokens without a kid, or every token when there is no JWKS
+// file. The files are read again by Reload so
+// that keys can be rotated without a restart.
+type KeySet struct {
+	pemPath  string
+	jwksPath string
+
+	mu       sync.RWMutex
+	pemKey   *rsa.PublicKey
+	jwksKeys map[string]*rsa.PublicKey
+	modified time.Time
+}
+
+// Key returns the key for kid
+func (s *KeySet) Key(kid string) (*rsa.PublicKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if kid == "" {
+		if s.pemKey != nil {
+			return s.pemKey, true
+		}
+
+		// a set holding a single key needs no kid to choose it
+		if len(s.jwksKeys) == 1 {
+			for _, k := range s.jwksKeys {
+				return k, true
+			}
+		}
+
+		return nil, false
+	}
+
+	k, ok := s.jwksKeys[kid]
+	if !ok && s.jwksKeys == nil && s.pemKey != nil {
+		// without a JWKS file every token is verified with the PEM key
+		return s.pemKey, true
+	}
+
+	return k, ok
+}
+
+// Reload reads the key files again, the current keys are kept when either
+// file can not be read
+func (s *KeySet) Reload() error {
+	var pemKey *rsa.PublicKey
+	if s.pemPath != "" {
+		b, err := ioutil.ReadFile(s.pemPath)
+		if err != nil {
+			return err
+		}
+
+		if pemKey, err = ParsePublicKey(b); err != nil {
+			return fmt.Errorf("%s: %v", s.pemPath, err)
+		}
+	}
+
+	var jwksKeys map[string]*rsa.PublicKey
+	var modified time.Time
+	if s.jwksPath != "" {
+		info, err := os.Stat(s.jwksPath)
+		if err != nil {
+			return err
+		}
+
+		b, err := ioutil.ReadFile(s.jwksPath)
+		if err != nil {
+			return err
+		}
+
+		if jwksKeys, err = ParseJWKS(b); err != nil {
+			return fmt.Errorf("%s: %v", s.jwksPath, err)
+		}
+		modified = info.ModTime()
+	}
+
+	s.mu.Lock()
+	s.pemKey, s.jwksKeys, s.modified = pemKey, jwksKeys, modified
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Changed returns true when the JWKS file has been modified since it was
+// last read
+func (s *KeySet) Changed() bool {
+	if s.jwksPath == "" {
+		return false
+	}
+
+	info, err := os.Stat(s.jwksPath)
+	if err != nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return !info.ModTime().Equal(s.modified)
+}
+
+// ParsePublicKey parses a PEM encoded RSA public key in either PKIX or
+// PKCS #1 form
+func ParsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		key := pkcs1PublicKey{}
+		if _, err := asn1.Unmarshal(block.Bytes, &key); err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: key.N, E: key.E}, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the key is not an RSA public key")
+	}
+
+	return rsaKey, nil
+}
+
+// ParseJWKS returns the RSA signing keys of a JSON Web Key Set keyed by their
+// kid, keys of other types or uses are ignored
+func ParseJWKS(b []byte) (map[string]*rsa.PublicKey, error) {
+	set := jwks{}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for i, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != algorithm) {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: invalid modulus: %v", i, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("key %d: invalid exponent", i)
+		}
+
+		if _, ok := keys[k.Kid]; ok {
+			return nil, fmt.Errorf("key %d: duplicate kid %q", i, k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA signing keys found")
+	}
+
+	return keys, nil
+}
+
+// LoadKeys reads the PEM encoded public key at pemPath and the JSON Web Key
+// Set at jwksPath, either path may be empty
+func LoadKeys(pemPath, jwksPath string) (*KeySet, error) {
+	if pemPath == "" && jwksPath == "" {
+		return nil, errors.New("a public key or JWKS file is required")
+	}
+
+	s := &KeySet{pemPath: pemPath, jwksPath: jwksPath}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
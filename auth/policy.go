@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Permissions granted to tokens by their scope or roles
+const (
+	PermissionRead  = "kittens:read"
+	PermissionWrite = "kittens:write"
+	PermissionAdmin = "admin"
+	// PermissionPublic marks a route which needs no token
+	PermissionPublic = "public"
+	// PermissionAuthenticated marks a route which needs any valid token
+	PermissionAuthenticated = "authenticated"
+)
+
+// Reasons a request is denied by a Policy
+const (
+	ReasonNoPolicy          = "no_policy"
+	ReasonMissingPermission = "missing_permission"
+)
+
+// DefaultRules protect every route served by the search service, routes
+// which are not listed are denied
+var DefaultRules = map[string]string{
+	"/health":        PermissionPublic,
+	"POST /":         PermissionRead,
+	"GET /suggest":   PermissionRead,
+	"POST /kittens":  PermissionWrite,
+	"GET /kittens/*": PermissionRead,
+	"/kittens/*":     PermissionWrite,
+	"/admin/*":       PermissionAdmin,
+}
+
+// rule requires permission for requests matching method and path, an empty
+// method matches every method and a path ending in * matches every path
+// with that prefix
+type rule struct {
+	method     string
+	path       string
+	prefix     bool
+	permission string
+}
+
+// Denied is returned when a request is not allowed by a Policy
+type Denied struct {
+	// Reason is ReasonNoPolicy or ReasonMissingPermission
+	Reason string `json:"reason"`
+	// Required is the permission the request needed
+	Required string `json:"required,omitempty"`
+}
+
+func (d *Denied) Error() string {
+	if d.Required == "" {
+		return "forbidden: " + d.Reason
+	}
+
+	return fmt.Sprintf("forbidden: %s %s", d.Reason, d.Required)
+}
+
+// Policy maps routes to the permission they require and roles to the
+// permissions they grant. Tokens are granted each permission named in their
+// scope claim and the permissions of each of their roles.
+type Policy struct {
+	rules []rule
+	roles map[string][]string
+}
+
+// Required returns the permission needed by a request, ok is false when no
+// rule matches and the request must be denied. When several rules match an
+// exact path beats a prefix, a longer prefix beats a shorter one and a rule
+// for the method beats a rule for every method.
+func (p *Policy) Required(method, path string) (permission string, ok bool) {
+	var best *rule
+	for i := range p.rules {
+		r := &p.rules[i]
+		if !r.matches(method, path) {
+			continue
+		}
+
+		if best == nil || r.beats(best) {
+			best = r
+		}
+	}
+
+	if best == nil {
+		return "", false
+	}
+
+	return best.permission, true
+}
+
+// Authorize returns a *Denied error unless claims grant the permission
+// required by the request, claims may be nil for a request without a token
+func (p *Policy) Authorize(claims *Claims, method, path string) error {
+	permission, ok := p.Required(method, path)
+	if !ok {
+		return &Denied{Reason: ReasonNoPolicy}
+	}
+
+	switch {
+	case permission == PermissionPublic:
+		return nil
+	case claims != nil && permission == PermissionAuthenticated:
+		return nil
+	case claims != nil && p.Granted(claims)[permission]:
+		return nil
+	}
+
+	return &Denied{Reason: ReasonMissingPermission, Required: permission}
+}
+
+// Granted returns every permission granted to claims
+func (p *Policy) Granted(claims *Claims) map[string]bool {
+	granted := make(map[string]bool)
+	for _, s := range strings.Fields(claims.Scope) {
+		granted[s] = true
+	}
+
+	for _, role := range claims.Roles {
+		for _, permission := range p.roles[role] {
+			granted[permission] = true
+		}
+	}
+
+	return granted
+}
+
+func (r *rule) matches(method, path string) bool {
+	if r.method != "" && r.method != method {
+		return false
+	}
+
+	if r.prefix {
+		return strings.HasPrefix(path, r.path)
+	}
+
+	return path == r.path
+}
+
+func (r *rule) beats(other *rule) bool {
+	if r.prefix != other.prefix {
+		return !r.prefix
+	}
+
+	if len(r.path) != len(other.path) {
+		return len(r.path) > len(other.path)
+	}
+
+	return r.method != "" && other.method == ""
+}
+
+// NewPolicy parses rules keyed by "METHOD PATH" or "PATH" and roles mapping
+// a role to a space separated list of permissions
+func NewPolicy(rules map[string]string, roles map[string]string) (*Policy, error) {
+	p := &Policy{roles: make(map[string][]string)}
+
+	// rules are parsed in a fixed order so that errors are reproducible
+	keys := make([]string, 0, len(rules))
+	for k := range rules {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		r := rule{permission: rules[k]}
+
+		fields := strings.Fields(k)
+		switch len(fields) {
+		case 1:
+			r.path = fields[0]
+		case 2:
+			r.method, r.path = strings.ToUpper(fields[0]), fields[1]
+		default:
+			return nil, fmt.Errorf("rule %q: expected METHOD PATH or PATH", k)
+		}
+
+		if !strings.HasPrefix(r.path, "/") {
+			return nil, fmt.Errorf("rule %q: the path must start with /", k)
+		}
+
+		if r.permission == "" {
+			return nil, fmt.Errorf("rule %q: a permission is required", k)
+		}
+
+		if strings.HasSuffix(r.path, "*") {
+			r.path, r.prefix = strings.TrimSuffix(r.path, "*"), true
+		}
+
+		p.rules = append(p.rules, r)
+	}
+
+	for role, permissions := range roles {
+		p.roles[role] = strings.Fields(permissions)
+	}
+
+	return p, nil
+}
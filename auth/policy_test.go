@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredPrefersTheMostSpecificRule(t *testing.T) {
+	p, err := NewPolicy(DefaultRules, nil)
+	assert.Nil(t, err)
+
+	for request, expected := range map[[2]string]string{
+		{"GET", "/health"}:        PermissionPublic,
+		{"POST", "/"}:             PermissionRead,
+		{"GET", "/kittens/1"}:     PermissionRead,
+		{"DELETE", "/kittens/1"}:  PermissionWrite,
+		{"POST", "/kittens"}:      PermissionWrite,
+		{"POST", "/admin/reload"}: PermissionAdmin,
+	} {
+		permission, ok := p.Required(request[0], request[1])
+		assert.True(t, ok, "%v", request)
+		assert.Equal(t, expected, permission, "%v", request)
+	}
+
+	_, ok := p.Required("GET", "/")
+	assert.False(t, ok)
+}
+
+func TestAuthorizeGrantsPermissionsFromScopesAndRoles(t *testing.T) {
+	p, err := NewPolicy(DefaultRules, map[string]string{"editor": "kittens:read kittens:write"})
+	assert.Nil(t, err)
+
+	reader := &Claims{Scope: "openid kittens:read"}
+	editor := &Claims{Roles: []string{"editor"}}
+
+	assert.Nil(t, p.Authorize(reader, "POST", "/"))
+	assert.Equal(t, &Denied{Reason: ReasonMissingPermission, Required: PermissionWrite}, p.Authorize(reader, "POST", "/kittens"))
+	assert.Nil(t, p.Authorize(editor, "POST", "/kittens"))
+	assert.Equal(t, &Denied{Reason: ReasonMissingPermission, Required: PermissionAdmin}, p.Authorize(editor, "POST", "/admin/reload"))
+	assert.Equal(t, &Denied{Reason: ReasonNoPolicy}, p.Authorize(editor, "GET", "/new-endpoint"))
+}
+
+func TestNewPolicyRejectsInvalidRules(t *testing.T) {
+	for _, rules := range []map[string]string{
+		{"GET": PermissionRead},
+		{"GET /a /b": PermissionRead},
+		{"/kittens": ""},
+	} {
+		_, err := NewPolicy(rules, nil)
+		assert.NotNil(t, err, "%v", rules)
+	}
+}
@@ -0,0 +1,185 @@
+// Package auth verifies the RS256 JSON Web Tokens issued by the auth service
+// and carries their claims through a request context.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// algorithm is the only signing algorithm accepted, tokens must not be able
+// to choose a weaker one
+const algorithm = "RS256"
+
+// Errors returned by Verify, each describes why a token was rejected
+var (
+	ErrMalformed   = errors.New("malformed token")
+	ErrAlgorithm   = errors.New("unsupported signing algorithm")
+	ErrUnknownKey  = errors.New("unknown signing key")
+	ErrSignature   = errors.New("invalid signature")
+	ErrExpired     = errors.New("token has expired")
+	ErrNotYetValid = errors.New("token is not valid yet")
+	ErrIssuer      = errors.New("unexpected issuer")
+	ErrAudience    = errors.New("unexpected audience")
+)
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Claims are the registered claims of a token and the scope and roles
+// granted to it
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	// Scope is a space separated list of the permissions granted
+	Scope string `json:"scope"`
+	// Roles are granted permissions by the Policy
+	Roles []string `json:"roles"`
+}
+
+// Audience is the aud claim which may be a single string or a list
+type Audience []string
+
+// UnmarshalJSON accepts a string or an array of strings
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+// Contains returns true when aud is one of the audiences
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Verifier checks the signature and claims of tokens
+type Verifier struct {
+	keys *KeySet
+	// issuer and audience are required to match the token when they are not
+	// empty
+	issuer   string
+	audience string
+	// leeway allows for clock skew between the issuer and this service when
+	// checking the exp and nbf claims
+	leeway time.Duration
+	now    func() time.Time
+}
+
+// Verify returns the claims of token when it is signed by a known key and is
+// currently valid for the issuer and audience
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	h := header{}
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if h.Alg != algorithm {
+		return nil, ErrAlgorithm
+	}
+
+	key, ok := v.keys.Key(h.Kid)
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrSignature
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	now := v.now()
+	if claims.ExpiresAt == 0 || now.Add(-v.leeway).Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+
+	if claims.NotBefore != 0 && now.Add(v.leeway).Unix() < claims.NotBefore {
+		return nil, ErrNotYetValid
+	}
+
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return nil, ErrIssuer
+	}
+
+	if v.audience != "" && !claims.Audience.Contains(v.audience) {
+		return nil, ErrAudience
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying claims
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext returns the claims of the authenticated request, if any
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
+
+// NewVerifier creates a Verifier for tokens signed by keys
+func NewVerifier(keys *KeySet, issuer, audience string, leeway time.Duration) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   leeway,
+		now:      time.Now,
+	}
+}
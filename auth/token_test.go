@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	signingKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	rotatedKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	now           = time.Unix(1500000000, 0)
+)
+
+func TestVerifyReturnsTheClaimsOfAValidToken(t *testing.T) {
+	v := verifier(t)
+
+	claims, err := v.Verify(sign(t, signingKey, "", validClaims()))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "nic", claims.Subject)
+	assert.Equal(t, "kittens:read", claims.Scope)
+	assert.Equal(t, Audience{"search"}, claims.Audience)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	v := verifier(t)
+
+	expired := validClaims()
+	expired["exp"] = now.Add(-time.Minute).Unix()
+
+	early := validClaims()
+	early["nbf"] = now.Add(time.Minute).Unix()
+
+	issuer := validClaims()
+	issuer["iss"] = "someone-else"
+
+	audience := validClaims()
+	audience["aud"] = []string{"kittens", "billing"}
+
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+
+	token := sign(t, signingKey, "", validClaims())
+	tampered := token[:len(token)-4] + "AAAA"
+
+	for expected, token := range map[error]string{
+		ErrMalformed:   "not.a-token",
+		ErrSignature:   tampered,
+		ErrExpired:     sign(t, signingKey, "", expired),
+		ErrNotYetValid: sign(t, signingKey, "", early),
+		ErrIssuer:      sign(t, signingKey, "", issuer),
+		ErrAudience:    sign(t, signingKey, "", audience),
+	} {
+		_, err := v.Verify(token)
+		assert.Equal(t, expected, err)
+	}
+
+	_, err := v.Verify(sign(t, signingKey, "", noExpiry))
+	assert.Equal(t, ErrExpired, err)
+
+	_, err = v.Verify(sign(t, rotatedKey, "", validClaims()))
+	assert.Equal(t, ErrSignature, err)
+}
+
+func TestVerifyRejectsOtherAlgorithms(t *testing.T) {
+	v := verifier(t)
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	payload, _ := json.Marshal(validClaims())
+	token := header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+
+	_, err := v.Verify(token)
+
+	assert.Equal(t, ErrAlgorithm, err)
+}
+
+func TestVerifyAllowsForClockSkew(t *testing.T) {
+	v := verifier(t)
+	v.leeway = time.Minute
+
+	claims := validClaims()
+	claims["exp"] = now.Add(-30 * time.Second).Unix()
+
+	_, err := v.Verify(sign(t, signingKey, "", claims))
+
+	assert.Nil(t, err)
+}
+
+func TestKeysAreRotatedThroughTheJWKSFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwks.json")
+	writeJWKS(t, path, map[string]*rsa.PublicKey{"2017-07": &signingKey.PublicKey})
+
+	keys, err := LoadKeys("", path)
+	assert.Nil(t, err)
+	v := NewVerifier(keys, "auth", "search", 0)
+	v.now = func() time.Time { return now }
+
+	_, err = v.Verify(sign(t, signingKey, "2017-07", validClaims()))
+	assert.Nil(t, err)
+
+	_, err = v.Verify(sign(t, rotatedKey, "2017-08", validClaims()))
+	assert.Equal(t, ErrUnknownKey, err)
+
+	// the new key is published alongside the old one
+	writeJWKS(t, path, map[string]*rsa.PublicKey{"2017-07": &signingKey.PublicKey, "2017-08": &rotatedKey.PublicKey})
+	os.Chtimes(path, now, now)
+	assert.True(t, keys.Changed())
+	assert.Nil(t, keys.Reload())
+
+	_, err = v.Verify(sign(t, rotatedKey, "2017-08", validClaims()))
+	assert.Nil(t, err)
+	_, err = v.Verify(sign(t, signingKey, "2017-07", validClaims()))
+	assert.Nil(t, err)
+}
+
+func TestTheBundledSampleKeyParses(t *testing.T) {
+	b, err := ioutil.ReadFile("../sample_key.pub")
+	assert.Nil(t, err)
+
+	key, err := ParsePublicKey(b)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2048, key.N.BitLen())
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "nic",
+		"iss":   "auth",
+		"aud":   "search",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"scope": "kittens:read",
+	}
+}
+
+func verifier(t *testing.T) *Verifier {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	der, err := x509.MarshalPKIXPublicKey(&signingKey.PublicKey)
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, "key.pub")
+	ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	keys, err := LoadKeys(path, "")
+	assert.Nil(t, err)
+
+	v := NewVerifier(keys, "auth", "search", 0)
+	v.now = func() time.Time { return now }
+
+	return v
+}
+
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	h := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+
+	header, _ := json.Marshal(h)
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	assert.Nil(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJWKS(t *testing.T, path string, keys map[string]*rsa.PublicKey) {
+	set := jwks{}
+	for kid, k := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+
+	b, _ := json.Marshal(set)
+	assert.Nil(t, ioutil.WriteFile(path, b, 0644))
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+)
+
+// the kitten fields which can be mapped to columns
+const (
+	fieldId     = "id"
+	fieldName   = "name"
+	fieldWeight = "weight"
+)
+
+var fieldNames = []string{fieldId, fieldName, fieldWeight}
+
+// columnMap maps each kitten field to the CSV column or JSON key holding it,
+// columns are matched case insensitively
+type columnMap map[string]string
+
+// parseColumns parses a mapping such as "id=kitten_id,weight=kg", fields
+// which are not mentioned use their own name as the column
+func parseColumns(s string) (columnMap, error) {
+	columns := columnMap{}
+	for _, field := range fieldNames {
+		columns[field] = field
+	}
+
+	if strings.TrimSpace(s) == "" {
+		return columns, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("column mapping %q must be in the form field=column", pair)
+		}
+
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q, fields are %s", field, strings.Join(fieldNames, ", "))
+		}
+
+		columns[field] = strings.TrimSpace(parts[1])
+	}
+
+	return columns, nil
+}
+
+// detectFormat returns format or, when it is empty, the format implied by
+// the extension of path
+func detectFormat(format, path string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = formatCSV
+		case ".jsonl", ".ndjson":
+			format = formatJSONL
+		}
+	}
+
+	switch format {
+	case formatCSV, formatJSONL:
+		return format, nil
+	case "":
+		return "", fmt.Errorf("unable to detect the format of %q, use -format", path)
+	}
+
+	return "", fmt.Errorf("unknown format %q, formats are csv and jsonl", format)
+}
+
+// rowError is returned by a reader when a single row can not be converted to
+// a kitten, reading can continue with the next row
+type rowError struct {
+	Row int
+	Err error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+// reader reads kittens from an input file, rows are numbered from 1 and do
+// not include a CSV header or blank lines
+type reader interface {
+	// Read returns the next kitten and its row, a *rowError when the row
+	// is invalid or io.EOF when there are no more rows
+	Read() (data.Kitten, int, error)
+}
+
+// writer writes kittens to an output file
+type writer interface {
+	Write(k data.Kitten) error
+	Flush() error
+}
+
+func newReader(format string, r io.Reader, columns columnMap) (reader, error) {
+	if format == formatCSV {
+		return newCSVReader(r, columns)
+	}
+
+	return &jsonlReader{r: bufio.NewReader(r), columns: columns}, nil
+}
+
+func newWriter(format string, w io.Writer, columns columnMap) (writer, error) {
+	if format == formatCSV {
+		return newCSVWriter(w, columns)
+	}
+
+	return &jsonlWriter{w: bufio.NewWriter(w), columns: columns}, nil
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	row     int
+	indexes map[string]int
+}
+
+// newCSVReader reads the header row and finds the column of every field
+func newCSVReader(r io.Reader, columns columnMap) (*csvReader, error) {
+	c := &csvReader{r: csv.NewReader(r), indexes: make(map[string]int)}
+	c.r.FieldsPerRecord = -1
+
+	header, err := c.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the CSV header: %v", err)
+	}
+
+	for _, field := range fieldNames {
+		c.indexes[field] = -1
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), columns[field]) {
+				c.indexes[field] = i
+			}
+		}
+
+		if c.indexes[field] < 0 {
+			return nil, fmt.Errorf("the CSV header has no %q column for the kitten %s", columns[field], field)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *csvReader) Read() (data.Kitten, int, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return data.Kitten{}, 0, io.EOF
+	}
+
+	c.row++
+	if _, ok := err.(*csv.ParseError); ok {
+		return data.Kitten{}, c.row, &rowError{Row: c.row, Err: err}
+	}
+	if err != nil {
+		return data.Kitten{}, c.row, err
+	}
+
+	value := func(field string) string {
+		if i := c.indexes[field]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+
+		return ""
+	}
+
+	weight, err := strconv.ParseFloat(value(fieldWeight), 32)
+	if err != nil {
+		return data.Kitten{}, c.row, &rowError{Row: c.row, Err: fmt.Errorf("weight %q is not a number", value(fieldWeight))}
+	}
+
+	return data.Kitten{Id: value(fieldId), Name: value(fieldName), Weight: float32(weight)}, c.row, nil
+}
+
+type jsonlReader struct {
+	r       *bufio.Reader
+	row     int
+	columns columnMap
+}
+
+func (j *jsonlReader) Read() (data.Kitten, int, error) {
+	var line []byte
+	for len(bytes.TrimSpace(line)) == 0 {
+		var err error
+		line, err = j.r.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+			return data.Kitten{}, 0, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return data.Kitten{}, 0, err
+		}
+	}
+
+	j.row++
+
+	object := make(map[string]interface{})
+	if err := json.Unmarshal(line, &object); err != nil {
+		return data.Kitten{}, j.row, &rowError{Row: j.row, Err: fmt.Errorf("invalid JSON: %v", err)}
+	}
+
+	value := func(field string) interface{} {
+		for key, v := range object {
+			if strings.EqualFold(key, j.columns[field]) {
+				return v
+			}
+		}
+
+		return nil
+	}
+
+	k := data.Kitten{}
+	for _, field := range []string{fieldId, fieldName} {
+		var s string
+		switch v := value(field).(type) {
+		case string:
+			s = v
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+		default:
+			return data.Kitten{}, j.row, &rowError{Row: j.row, Err: fmt.Errorf("%s must be a string", field)}
+		}
+
+		if field == fieldId {
+			k.Id = s
+		} else {
+			k.Name = s
+		}
+	}
+
+	switch v := value(fieldWeight).(type) {
+	case float64:
+		k.Weight = float32(v)
+	case string:
+		weight, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return data.Kitten{}, j.row, &rowError{Row: j.row, Err: fmt.Errorf("weight %q is not a number", v)}
+		}
+
+		k.Weight = float32(weight)
+	default:
+		return data.Kitten{}, j.row, &rowError{Row: j.row, Err: fmt.Errorf("weight must be a number")}
+	}
+
+	return k, j.row, nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer, columns columnMap) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	err := c.w.Write([]string{columns[fieldId], columns[fieldName], columns[fieldWeight]})
+
+	return c, err
+}
+
+func (c *csvWriter) Write(k data.Kitten) error {
+	return c.w.Write([]string{k.Id, k.Name, formatWeight(k.Weight)})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	w       *bufio.Writer
+	columns columnMap
+}
+
+func (j *jsonlWriter) Write(k data.Kitten) error {
+	line, err := json.Marshal(map[string]interface{}{
+		j.columns[fieldId]:     k.Id,
+		j.columns[fieldName]:   k.Name,
+		j.columns[fieldWeight]: json.Number(formatWeight(k.Weight)),
+	})
+	if err != nil {
+		return err
+	}
+
+	j.w.Write(line)
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
+
+// formatWeight formats a weight with the fewest digits which read back as
+// the same float32
+func formatWeight(weight float32) string {
+	return strconv.FormatFloat(float64(weight), 'f', -1, 32)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+// checkpoint records how far an import has got so that it can be resumed,
+// Rows is the number of rows of Source which have been committed
+type checkpoint struct {
+	Source string `json:"source"`
+	Rows   int    `json:"rows"`
+}
+
+// summary counts the outcome of an import
+type summary struct {
+	Read     int
+	Skipped  int
+	Created  int
+	Updated  int
+	Rejected int
+}
+
+func (s summary) String() string {
+	return fmt.Sprintf("read %d rows, skipped %d, created %d, updated %d, rejected %d",
+		s.Read, s.Skipped, s.Created, s.Updated, s.Rejected)
+}
+
+// importer reads kittens in batches and writes each batch to the store, when
+// store is nil the rows are only validated
+type importer struct {
+	store     data.Importer
+	options   data.ImportOptions
+	batchSize int
+	source    string
+	// checkpointPath is where progress is recorded after every batch, an
+	// empty path disables resuming
+	checkpointPath string
+	// progress receives a line after every batch and errors receives a line
+	// for every rejected row
+	progress io.Writer
+	errors   io.Writer
+}
+
+// run imports every row of r which was not committed by a previous run
+func (i *importer) run(ctx context.Context, r reader) (summary, error) {
+	s := summary{}
+
+	resume, err := i.loadCheckpoint()
+	if err != nil {
+		return s, err
+	}
+
+	// ids seen in the file are tracked during a dry run as the store is not
+	// there to reject duplicates
+	seen := make(map[string]bool)
+
+	var batch []data.Kitten
+	var rows []int
+	for {
+		k, row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if row > 0 && row <= resume {
+			s.Skipped++
+			continue
+		}
+
+		s.Read++
+		if e, ok := err.(*rowError); ok {
+			i.reject(&s, e.Row, "", e.Err.Error())
+			continue
+		}
+		if err != nil {
+			return s, err
+		}
+
+		if i.store == nil {
+			if err := k.Validate(); err != nil {
+				i.reject(&s, row, k.Id, err.Error())
+			} else if seen[k.Id] {
+				i.reject(&s, row, k.Id, "duplicate id in import")
+			}
+
+			seen[k.Id] = true
+			continue
+		}
+
+		batch = append(batch, k)
+		rows = append(rows, row)
+		if len(batch) == i.batchSize {
+			if err := i.flush(ctx, &s, batch, rows); err != nil {
+				return s, err
+			}
+
+			batch, rows = batch[:0], rows[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := i.flush(ctx, &s, batch, rows); err != nil {
+			return s, err
+		}
+	}
+
+	// a completed import starts from the beginning next time
+	if i.checkpointPath != "" && i.store != nil {
+		if err := os.Remove(i.checkpointPath); err != nil && !os.IsNotExist(err) {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
+
+// flush writes a batch and records that its rows have been committed
+func (i *importer) flush(ctx context.Context, s *summary, batch []data.Kitten, rows []int) error {
+	result, err := i.store.Import(ctx, batch, i.options)
+	if err != nil {
+		return fmt.Errorf("import of rows %d to %d failed: %v", rows[0], rows[len(rows)-1], err)
+	}
+
+	s.Created += result.Created
+	s.Updated += result.Updated
+	for _, e := range result.Errors {
+		i.reject(s, rows[e.Row], e.Id, e.Error)
+	}
+
+	if err := i.saveCheckpoint(rows[len(rows)-1]); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(i.progress, s)
+	return nil
+}
+
+func (i *importer) reject(s *summary, row int, id, reason string) {
+	s.Rejected++
+
+	if id == "" {
+		fmt.Fprintf(i.errors, "row %d: %s\n", row, reason)
+		return
+	}
+
+	fmt.Fprintf(i.errors, "row %d (id %s): %s\n", row, id, reason)
+}
+
+// loadCheckpoint returns the number of rows committed by a previous run of
+// the same import
+func (i *importer) loadCheckpoint() (int, error) {
+	if i.checkpointPath == "" {
+		return 0, nil
+	}
+
+	b, err := ioutil.ReadFile(i.checkpointPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	c := checkpoint{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return 0, fmt.Errorf("invalid checkpoint %s: %v", i.checkpointPath, err)
+	}
+
+	if c.Source != i.source {
+		return 0, fmt.Errorf("checkpoint %s is for %s not %s", i.checkpointPath, c.Source, i.source)
+	}
+
+	fmt.Fprintf(i.progress, "resuming after row %d\n", c.Rows)
+	return c.Rows, nil
+}
+
+// saveCheckpoint atomically records that every row up to and including row
+// has been committed
+func (i *importer) saveCheckpoint(row int) error {
+	if i.checkpointPath == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(checkpoint{Source: i.source, Rows: row})
+	if err != nil {
+		return err
+	}
+
+	temp := i.checkpointPath + ".tmp"
+	if err := ioutil.WriteFile(temp, b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, i.checkpointPath)
+}
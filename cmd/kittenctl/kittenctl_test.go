@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSVReaderMapsColumnsAndReportsInvalidRows(t *testing.T) {
+	columns, err := parseColumns("id=Kitten ID,weight=kg")
+	assert.Nil(t, err)
+
+	r, err := newReader(formatCSV, strings.NewReader("name,kg,Kitten ID\nTom,15,4\nJerry,heavy,5\n"), columns)
+	assert.Nil(t, err)
+
+	k, row, err := r.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, row)
+	assert.Equal(t, data.Kitten{Id: "4", Name: "Tom", Weight: 15}, k)
+
+	_, row, err = r.Read()
+	assert.IsType(t, &rowError{}, err)
+	assert.Equal(t, 2, row)
+
+	_, _, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCSVReaderRequiresEveryMappedColumn(t *testing.T) {
+	columns, _ := parseColumns("")
+
+	_, err := newReader(formatCSV, strings.NewReader("id,name\n"), columns)
+
+	assert.NotNil(t, err)
+}
+
+func TestExportedKittensReadBackUnchanged(t *testing.T) {
+	kittens := []data.Kitten{{Id: "1", Name: "Felix, the cat", Weight: 12.3}, {Id: "2", Name: "Tom", Weight: 15}}
+	columns, _ := parseColumns("weight=kg")
+
+	for _, format := range []string{formatCSV, formatJSONL} {
+		out := &bytes.Buffer{}
+		assert.Nil(t, export(format, out, columns, kittens))
+
+		r, err := newReader(format, out, columns)
+		assert.Nil(t, err)
+
+		for _, expected := range kittens {
+			k, _, err := r.Read()
+			assert.Nil(t, err, format)
+			assert.Equal(t, expected, k, format)
+		}
+	}
+}
+
+func TestDryRunReportsInvalidAndDuplicateRows(t *testing.T) {
+	columns, _ := parseColumns("")
+	r, _ := newReader(formatJSONL, strings.NewReader(`{"id":"4","name":"Tom","weight":15}
+{"id":"5","name":"","weight":1}
+
+{"id":"4","name":"Tom","weight":15}
+`), columns)
+
+	errors := &bytes.Buffer{}
+	i := &importer{batchSize: 10, progress: ioutil.Discard, errors: errors}
+
+	s, err := i.run(context.Background(), r)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.Read)
+	assert.Equal(t, 2, s.Rejected)
+	assert.Contains(t, errors.String(), "row 2 (id 5): name must not be empty")
+	assert.Contains(t, errors.String(), "row 3 (id 4): duplicate id in import")
+}
+
+func TestImportResumesAfterTheLastCommittedRow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kittenctl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint.json")
+	input := "id,name,weight\n4,Tom,15\n5,Jerry,2\n6,Sylvester,20\n"
+	columns, _ := parseColumns("")
+
+	// a previous run committed the first two rows
+	i := &importer{batchSize: 2, source: "kittens.csv", checkpointPath: path, progress: ioutil.Discard, errors: ioutil.Discard}
+	assert.Nil(t, i.saveCheckpoint(2))
+
+	store := &data.MemoryStore{}
+	i.store = store
+
+	r, _ := newReader(formatCSV, strings.NewReader(input), columns)
+	s, err := i.run(context.Background(), r)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Skipped)
+	assert.Equal(t, 1, s.Created)
+
+	_, err = store.Get(context.Background(), "4")
+	assert.Equal(t, data.ErrNotFound, err)
+	_, err = store.Get(context.Background(), "6")
+	assert.Nil(t, err)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
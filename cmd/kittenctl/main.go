@@ -0,0 +1,250 @@
+// Command kittenctl imports kittens into and exports kittens from a kitten
+// store.
+//
+//	kittenctl import [flags] FILE
+//	kittenctl export [flags] [FILE]
+//
+// Files are CSV with a header row or JSON Lines, the format is detected from
+// the file extension unless -format is given.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+const usage = `usage: kittenctl COMMAND [flags]
+
+commands:
+  import  load kittens from a CSV or JSON Lines file
+  export  write every kitten to a CSV or JSON Lines file
+
+run kittenctl COMMAND -h for the flags of a command`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kittenctl:", err)
+		os.Exit(1)
+	}
+}
+
+// storeFlags are the flags which choose the store a command operates on
+type storeFlags struct {
+	kind       string
+	connection string
+	dir        string
+}
+
+func (s *storeFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&s.kind, "store", "mysql", "the store to use, mysql or file")
+	flags.StringVar(&s.connection, "mysql", os.Getenv("MYSQL_CONNECTION"), "the MySQL connection string")
+	flags.StringVar(&s.dir, "dir", os.Getenv("SEARCH_DATA_DIR"), "the directory of the file store")
+}
+
+// kittenStore is a store opened by kittenctl, it must be closed to release
+// its connections or the lock on the file store directory
+type kittenStore interface {
+	data.Store
+	io.Closer
+}
+
+func (s *storeFlags) open() (kittenStore, error) {
+	switch s.kind {
+	case "mysql":
+		return data.NewMySQLStore(s.connection)
+	case "file":
+		if s.dir == "" {
+			return nil, fmt.Errorf("the file store needs a -dir")
+		}
+
+		return data.OpenFileStore(s.dir, data.DefaultFileStoreOptions())
+	}
+
+	return nil, fmt.Errorf("unknown store %q", s.kind)
+}
+
+// closeStore closes s and reports its error through err unless err is
+// already set
+func closeStore(s kittenStore, err *error) {
+	if closeErr := s.Close(); *err == nil {
+		*err = closeErr
+	}
+}
+
+func runImport(args []string) (err error) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kittenctl import [flags] FILE")
+		flags.PrintDefaults()
+	}
+
+	stores := &storeFlags{}
+	stores.register(flags)
+	format := flags.String("format", "", "the file format, csv or jsonl")
+	mapping := flags.String("columns", "", `maps kitten fields to columns, e.g. "id=kitten_id,weight=kg"`)
+	upsert := flags.Bool("upsert", false, "replace kittens which already exist")
+	dryRun := flags.Bool("dry-run", false, "validate the file without writing any kittens")
+	batchSize := flags.Int("batch", data.ImportBatchSize, "the number of kittens written in each transaction")
+	resume := flags.String("checkpoint", "", "record progress in this file and resume from it after a failure")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *batchSize < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	path := flags.Arg(0)
+	f, err := detectFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	columns, err := parseColumns(*mapping)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, err := newReader(f, file, columns)
+	if err != nil {
+		return err
+	}
+
+	i := &importer{
+		options:        data.ImportOptions{Upsert: *upsert},
+		batchSize:      *batchSize,
+		source:         path,
+		checkpointPath: *resume,
+		progress:       os.Stderr,
+		errors:         os.Stderr,
+	}
+
+	if !*dryRun {
+		var store kittenStore
+		store, err = stores.open()
+		if err != nil {
+			return err
+		}
+		defer closeStore(store, &err)
+
+		importer, ok := store.(data.Importer)
+		if !ok {
+			return fmt.Errorf("the %s store does not support imports", stores.kind)
+		}
+
+		i.store = importer
+	}
+
+	s, err := i.run(context.Background(), r)
+	fmt.Fprintln(os.Stderr, s)
+	if err != nil {
+		return err
+	}
+
+	if s.Rejected > 0 {
+		return fmt.Errorf("%d rows were rejected", s.Rejected)
+	}
+
+	return nil
+}
+
+func runExport(args []string) (err error) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kittenctl export [flags] [FILE]")
+		flags.PrintDefaults()
+	}
+
+	stores := &storeFlags{}
+	stores.register(flags)
+	format := flags.String("format", "", "the file format, csv or jsonl, required when writing to stdout")
+	mapping := flags.String("columns", "", `maps kitten fields to columns, e.g. "id=kitten_id,weight=kg"`)
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	path := flags.Arg(0)
+	f, err := detectFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	columns, err := parseColumns(*mapping)
+	if err != nil {
+		return err
+	}
+
+	store, err := stores.open()
+	if err != nil {
+		return err
+	}
+	defer closeStore(store, &err)
+
+	kittens, err := store.All(context.Background())
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if path != "" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	if err := export(f, out, columns, kittens); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d kittens\n", len(kittens))
+	return nil
+}
+
+// export writes kittens to out in format
+func export(format string, out io.Writer, columns columnMap, kittens []data.Kitten) error {
+	w, err := newWriter(format, out, columns)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range kittens {
+		if err := w.Write(k); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
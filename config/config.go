@@ -0,0 +1,468 @@
+// Package config holds the settings of the search service. Settings are
+// layered, the defaults are overridden by a JSON config file, which is
+// overridden by environment variables, which are overridden by command line
+// flags.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/index"
+	"github.com/building-microservices-with-go/chapter10-services-search/ratelimit"
+	log "github.com/sirupsen/logrus"
+)
+
+// redacted replaces secrets when a Config is printed
+const redacted = "REDACTED"
+
+// DefaultPublicKey is the sample key which the Dockerfile copies next to the
+// service, tokens are verified with it unless other keys are configured
+const DefaultPublicKey = "sample_key.pub"
+
+// Config is the complete configuration of the search service
+type Config struct {
+	// Address is the host and port the HTTP server listens on
+	Address string `json:"address"`
+	// LogLevel is one of debug, info, warning, error, fatal or panic
+	LogLevel  string    `json:"log_level"`
+	Statsd    Statsd    `json:"statsd"`
+	Store     Store     `json:"store"`
+	Server    Server    `json:"server"`
+	Search    Search    `json:"search"`
+	Cache     Cache     `json:"cache"`
+	Admin     Admin     `json:"admin"`
+	Auth      Auth      `json:"auth"`
+	RateLimit RateLimit `json:"rate_limit"`
+}
+
+// Statsd is where metrics are sent
+type Statsd struct {
+	Address string `json:"address"`
+	// Namespace prefixes the name of every metric
+	Namespace string `json:"namespace"`
+}
+
+// Store chooses the kitten store and how it is opened
+type Store struct {
+	// Kind is mysql or file
+	Kind string `json:"kind"`
+	// MySQLConnection is the DSN of the MySQL store, it may hold a password
+	// so it is redacted when the config is printed
+	MySQLConnection string `json:"mysql_connection"`
+	// DataDir is the directory of the file store
+	DataDir string `json:"data_dir"`
+	// Fsync is the sync policy of the file store, always, interval or never
+	Fsync         string   `json:"fsync"`
+	FsyncInterval Duration `json:"fsync_interval"`
+	// Timeout bounds each attempt of a call to the mysql store, a reload of
+	// the index is bounded by the index load timeout instead
+	Timeout Duration `json:"timeout"`
+	// ImportTimeout bounds each import into the mysql store
+	ImportTimeout Duration `json:"import_timeout"`
+	// Retries is how many times a read of the mysql store failing with a
+	// transient error is tried again after a jittered Backoff, which doubles
+	// for each retry up to MaxBackoff
+	Retries    int      `json:"retries"`
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+	// BreakerThreshold consecutive failures open the circuit breaker of the
+	// mysql store, calls are then refused for BreakerTimeout
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerTimeout   Duration `json:"breaker_timeout"`
+}
+
+// Server bounds how long the HTTP server spends on each connection and on
+// shutting down
+type Server struct {
+	// ReadTimeout is the time allowed to read a request including its body
+	ReadTimeout Duration `json:"read_timeout"`
+	// WriteTimeout is the time allowed from the end of reading the request
+	// headers to the end of writing the response
+	WriteTimeout Duration `json:"write_timeout"`
+	// IdleTimeout is how long a keep-alive connection waits for its next
+	// request
+	IdleTimeout Duration `json:"idle_timeout"`
+	// ShutdownTimeout is how long requests which are in progress when the
+	// service is stopped are given to complete
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// Search tunes the search index
+type Search struct {
+	// Boosts overrides the field boosts of the default ranking, e.g.
+	// "name:3,id:0.5"
+	Boosts string `json:"boosts"`
+	// ReloadInterval is how often the index and suggestions are rebuilt from
+	// the store
+	ReloadInterval Duration `json:"reload_interval"`
+	// LoadTimeout bounds how long a bulk load of the kittens may take
+	LoadTimeout Duration `json:"load_timeout"`
+}
+
+// Cache sizes the search result cache, see cache.Options
+type Cache struct {
+	MaxEntries  int      `json:"max_entries"`
+	MaxBytes    int      `json:"max_bytes"`
+	TTL         Duration `json:"ttl"`
+	NegativeTTL Duration `json:"negative_ttl"`
+	// SnapshotEntries is the number of last good search results kept to
+	// answer searches while the store is unavailable, 0 disables them
+	SnapshotEntries int `json:"snapshot_entries"`
+}
+
+// Admin secures the admin endpoints
+type Admin struct {
+	// Token is the bearer token required by the admin endpoints when tokens
+	// from the auth service are not verified, the endpoints are disabled when
+	// it is empty. Otherwise the auth policy secures the admin endpoints.
+	Token string `json:"token"`
+}
+
+// Auth configures the verification of the bearer tokens issued by the auth
+// service, requests are only left unauthenticated when Disabled is set
+type Auth struct {
+	// Disabled turns authentication off, it must be set explicitly
+	Disabled bool `json:"disabled"`
+	// PublicKey is the path of a PEM encoded RSA public key, it defaults to
+	// the sample key shipped with the service. Set it to "" in a config file
+	// to verify tokens with the JWKS alone.
+	PublicKey string `json:"public_key"`
+	// JWKS is the path of a JSON Web Key Set, it is read again when it
+	// changes so that signing keys can be rotated
+	JWKS string `json:"jwks"`
+	// Issuer and Audience must match the iss and aud claims when set
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	// Leeway allows for clock skew when checking the exp and nbf claims
+	Leeway Duration `json:"leeway"`
+	// JWKSRefresh is how often the JWKS file is checked for changes
+	JWKSRefresh Duration `json:"jwks_refresh"`
+	// Policy maps "METHOD PATH" or "PATH" to the permission a route requires,
+	// a path ending in * matches every path with that prefix and routes
+	// which match no rule are denied. Rules in a config file are added to
+	// the defaults, a default is changed by giving its key a new permission.
+	Policy map[string]string `json:"policy"`
+	// Roles maps a role claim to the space separated permissions it grants
+	Roles map[string]string `json:"roles"`
+}
+
+// RateLimit limits the requests of each client with a token bucket, a client
+// is identified by the subject of its token, then its X-API-Key header when
+// the key is listed in a tier, then its IP address
+type RateLimit struct {
+	// Tiers maps a tier name to its limit and clients, clients which are in
+	// no tier use the default tier. Tiers in a config file are added to the
+	// defaults.
+	Tiers map[string]RateLimitTier `json:"tiers"`
+}
+
+// RateLimitTier is the bucket and quotas given to each client of a tier, a
+// burst of 0 does not limit the rate and a quota of 0 does not limit the
+// requests in its period
+type RateLimitTier struct {
+	// Burst is the number of requests a client may make at once
+	Burst int `json:"burst"`
+	// Refill is the number of requests a second a client may sustain
+	Refill float64 `json:"refill"`
+	// Daily and Monthly are the number of requests a client may make in each
+	// calendar day and month in UTC
+	Daily   int `json:"daily"`
+	Monthly int `json:"monthly"`
+	// Subjects, APIKeys and IPs are space separated lists of the clients
+	// in the tier
+	Subjects string `json:"subjects"`
+	APIKeys  string `json:"api_keys"`
+	IPs      string `json:"ips"`
+}
+
+// Enabled returns true when requests must carry a valid token
+func (a Auth) Enabled() bool {
+	return !a.Disabled
+}
+
+// Duration is a time.Duration which is written in config files as a string
+// such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("invalid duration %s, expected a string such as \"5s\"", b)
+		}
+
+		*d = Duration(n)
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() Config {
+	return Config{
+		Address:  ":8082",
+		LogLevel: "debug",
+		Statsd: Statsd{
+			Address:   "127.0.0.1:8125",
+			Namespace: "chapter10.search.",
+		},
+		Store: Store{
+			Kind:             "mysql",
+			DataDir:          "kittens-data",
+			Fsync:            "always",
+			FsyncInterval:    Duration(time.Second),
+			Timeout:          Duration(2 * time.Second),
+			ImportTimeout:    Duration(time.Minute),
+			Retries:          2,
+			Backoff:          Duration(50 * time.Millisecond),
+			MaxBackoff:       Duration(time.Second),
+			BreakerThreshold: 5,
+			BreakerTimeout:   Duration(10 * time.Second),
+		},
+		Server: Server{
+			ReadTimeout:     Duration(10 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(120 * time.Second),
+			ShutdownTimeout: Duration(20 * time.Second),
+		},
+		Search: Search{
+			ReloadInterval: Duration(60 * time.Second),
+			LoadTimeout:    Duration(30 * time.Second),
+		},
+		Cache: Cache{
+			MaxEntries:      10000,
+			MaxBytes:        64 << 20,
+			TTL:             Duration(30 * time.Second),
+			NegativeTTL:     Duration(5 * time.Second),
+			SnapshotEntries: 1000,
+		},
+		Auth: Auth{
+			PublicKey:   DefaultPublicKey,
+			Leeway:      Duration(30 * time.Second),
+			JWKSRefresh: Duration(time.Minute),
+			Policy:      defaultPolicy(),
+			Roles: map[string]string{
+				"reader": auth.PermissionRead,
+				"editor": auth.PermissionRead + " " + auth.PermissionWrite,
+				"admin":  auth.PermissionRead + " " + auth.PermissionWrite + " " + auth.PermissionAdmin,
+			},
+		},
+		RateLimit: RateLimit{
+			Tiers: map[string]RateLimitTier{
+				ratelimit.DefaultTier: {Burst: 50, Refill: 20},
+			},
+		},
+	}
+}
+
+func defaultPolicy() map[string]string {
+	policy := make(map[string]string)
+	for route, permission := range auth.DefaultRules {
+		policy[route] = permission
+	}
+
+	return policy
+}
+
+// ValidationError lists every invalid setting of a Config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate returns a *ValidationError describing every invalid setting, or
+// nil when the config is usable
+func (c Config) Validate() error {
+	e := &ValidationError{}
+	problem := func(setting, format string, args ...interface{}) {
+		e.Problems = append(e.Problems, setting+": "+fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		problem("address", "%v", err)
+	}
+
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		problem("log_level", "%q is not a log level, use debug, info, warning, error, fatal or panic", c.LogLevel)
+	}
+
+	if _, _, err := net.SplitHostPort(c.Statsd.Address); err != nil {
+		problem("statsd.address", "%v", err)
+	}
+
+	switch c.Store.Kind {
+	case "mysql":
+		if c.Store.MySQLConnection == "" {
+			problem("store.mysql_connection", "is required by the mysql store")
+		}
+	case "file":
+		if c.Store.DataDir == "" {
+			problem("store.data_dir", "is required by the file store")
+		}
+	default:
+		problem("store.kind", "%q is not a store, use mysql or file", c.Store.Kind)
+	}
+
+	if _, err := data.ParseSyncPolicy(c.Store.Fsync); err != nil {
+		problem("store.fsync", "%v", err)
+	}
+
+	if c.Store.Retries < 0 {
+		problem("store.retries", "must not be negative")
+	}
+
+	if c.Store.BreakerThreshold <= 0 {
+		problem("store.breaker_threshold", "must be positive")
+	}
+
+	if c.Search.Boosts != "" {
+		if _, err := index.ParseBoosts(c.Search.Boosts); err != nil {
+			problem("search.boosts", "%v", err)
+		}
+	}
+
+	if c.Cache.MaxEntries <= 0 {
+		problem("cache.max_entries", "must be positive")
+	}
+
+	if c.Cache.MaxBytes <= 0 {
+		problem("cache.max_bytes", "must be positive")
+	}
+
+	if c.Cache.SnapshotEntries < 0 {
+		problem("cache.snapshot_entries", "must not be negative")
+	}
+
+	if _, err := auth.NewPolicy(c.Auth.Policy, c.Auth.Roles); err != nil {
+		problem("auth.policy", "%v", err)
+	}
+
+	if c.Auth.Enabled() && c.Auth.PublicKey == "" && c.Auth.JWKS == "" {
+		problem("auth", "a public_key or jwks is required unless auth is disabled")
+	}
+
+	if c.Auth.Leeway < 0 {
+		problem("auth.leeway", "must not be negative")
+	}
+
+	if _, ok := c.RateLimit.Tiers[ratelimit.DefaultTier]; !ok {
+		problem("rate_limit.tiers", "the %s tier is required", ratelimit.DefaultTier)
+	}
+
+	for name, tier := range c.RateLimit.Tiers {
+		if tier.Burst < 0 {
+			problem("rate_limit.tiers."+name+".burst", "must not be negative")
+		}
+
+		if tier.Burst > 0 && tier.Refill <= 0 {
+			problem("rate_limit.tiers."+name+".refill", "must be positive")
+		}
+
+		if tier.Daily < 0 {
+			problem("rate_limit.tiers."+name+".daily", "must not be negative")
+		}
+
+		if tier.Monthly < 0 {
+			problem("rate_limit.tiers."+name+".monthly", "must not be negative")
+		}
+	}
+
+	for setting, d := range map[string]Duration{
+		"store.fsync_interval":    c.Store.FsyncInterval,
+		"store.timeout":           c.Store.Timeout,
+		"store.import_timeout":    c.Store.ImportTimeout,
+		"store.backoff":           c.Store.Backoff,
+		"store.max_backoff":       c.Store.MaxBackoff,
+		"store.breaker_timeout":   c.Store.BreakerTimeout,
+		"server.read_timeout":     c.Server.ReadTimeout,
+		"server.write_timeout":    c.Server.WriteTimeout,
+		"server.idle_timeout":     c.Server.IdleTimeout,
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+		"search.reload_interval":  c.Search.ReloadInterval,
+		"search.load_timeout":     c.Search.LoadTimeout,
+		"cache.ttl":               c.Cache.TTL,
+		"cache.negative_ttl":      c.Cache.NegativeTTL,
+		"auth.jwks_refresh":       c.Auth.JWKSRefresh,
+	} {
+		if d <= 0 {
+			problem(setting, "must be a positive duration")
+		}
+	}
+
+	if len(e.Problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(e.Problems)
+	return e
+}
+
+// Redacted returns a copy of the config with every secret replaced
+func (c Config) Redacted() Config {
+	c.Store.MySQLConnection = redactDSN(c.Store.MySQLConnection)
+	if c.Admin.Token != "" {
+		c.Admin.Token = redacted
+	}
+
+	// the tiers are copied so that the keys of the original are kept
+	tiers := make(map[string]RateLimitTier, len(c.RateLimit.Tiers))
+	for name, tier := range c.RateLimit.Tiers {
+		if tier.APIKeys != "" {
+			tier.APIKeys = redacted
+		}
+		tiers[name] = tier
+	}
+	c.RateLimit.Tiers = tiers
+
+	return c
+}
+
+// String returns the config as indented JSON with secrets redacted
+func (c Config) String() string {
+	b, err := json.MarshalIndent(c.Redacted(), "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+
+	return string(b)
+}
+
+// redactDSN hides the password of a MySQL DSN of the form
+// user:password@protocol(address)/dbname
+func redactDSN(dsn string) string {
+	at := strings.LastIndex(dsn, "@")
+	if at < 0 {
+		return dsn
+	}
+
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 {
+		return dsn
+	}
+
+	return dsn[:colon+1] + redacted + dsn[at:]
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonConfig = `{
+	"address": ":9000",
+	"store": {"kind": "file", "data_dir": "/var/lib/kittens", "fsync": "interval"},
+	"server": {"read_timeout": "5s"},
+	"cache": {"max_entries": 500}
+}`
+
+func TestSourcesAreLayeredFileEnvironmentThenFlags(t *testing.T) {
+	path := writeFile(t, "search.json", jsonConfig)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	env := map[string]string{
+		"SEARCH_CONFIG":       path,
+		"SEARCH_FSYNC":        "never",
+		"SEARCH_READ_TIMEOUT": "7s",
+	}
+
+	c, args, err := Load([]string{"-read-timeout", "9s", "-cache-entries=50", "migrate"}, getenv(env))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"migrate"}, args)
+	assert.Equal(t, ":9000", c.Address)
+	assert.Equal(t, "file", c.Store.Kind)
+	assert.Equal(t, "/var/lib/kittens", c.Store.DataDir)
+	assert.Equal(t, "never", c.Store.Fsync)
+	assert.Equal(t, Duration(9*time.Second), c.Server.ReadTimeout)
+	assert.Equal(t, 50, c.Cache.MaxEntries)
+	// settings which are not overridden keep their defaults
+	assert.Equal(t, Default().Statsd, c.Statsd)
+	assert.Equal(t, Default().Server.WriteTimeout, c.Server.WriteTimeout)
+}
+
+func TestJSONConfigFilesAreSupported(t *testing.T) {
+	path := writeFile(t, "search.json", `{"log_level": "info", "search": {"reload_interval": "2m"}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c := Default()
+	err := LoadFile(path, &c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "info", c.LogLevel)
+	assert.Equal(t, Duration(2*time.Minute), c.Search.ReloadInterval)
+}
+
+func TestUnknownSettingsInAFileAreRejected(t *testing.T) {
+	path := writeFile(t, "search.json", `{"store": {"knd": "file"}, "adress": ":80"}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c := Default()
+	err := LoadFile(path, &c)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unknown settings adress, store.knd")
+}
+
+func TestYAMLConfigFilesAreRejected(t *testing.T) {
+	path := writeFile(t, "search.yaml", "store:\n  kind: file\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c := Default()
+	err := LoadFile(path, &c)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "YAML is not supported")
+	assert.Equal(t, Default().Store.Kind, c.Store.Kind)
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	c := Default()
+	c.Address = "8082"
+	c.LogLevel = "loud"
+	c.Store.MySQLConnection = ""
+	c.Cache.TTL = 0
+
+	err := c.Validate()
+
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Equal(t, []string{"address", "cache.ttl", "log_level", "store.mysql_connection"}, settingsOf(err.(*ValidationError)))
+}
+
+func TestAuthIsOnlyDisabledExplicitly(t *testing.T) {
+	c, _, err := Load(nil, getenv(map[string]string{}))
+	assert.Nil(t, err)
+	assert.True(t, c.Auth.Enabled())
+	assert.Equal(t, DefaultPublicKey, c.Auth.PublicKey)
+
+	c.Auth.PublicKey = ""
+	assert.Equal(t, []string{"auth"}, settingsOf(c.Validate().(*ValidationError)))
+
+	c, _, err = Load([]string{"-auth-disabled"}, getenv(map[string]string{}))
+	assert.Nil(t, err)
+	assert.False(t, c.Auth.Enabled())
+
+	c, _, err = Load(nil, getenv(map[string]string{"SEARCH_AUTH_DISABLED": "true"}))
+	assert.Nil(t, err)
+	assert.False(t, c.Auth.Enabled())
+}
+
+func TestRateLimitTiersAreReadFromAFile(t *testing.T) {
+	path := writeFile(t, "search.json", `{"rate_limit": {"tiers": {"partner": {"burst": 100, "refill": 2.5, "monthly": 100000, "subjects": "acme globex"}}}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	c := Default()
+	err := LoadFile(path, &c)
+
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimitTier{Burst: 100, Refill: 2.5, Monthly: 100000, Subjects: "acme globex"}, c.RateLimit.Tiers["partner"])
+	assert.Equal(t, Default().RateLimit.Tiers["default"], c.RateLimit.Tiers["default"])
+
+	c.RateLimit.Tiers["partner"] = RateLimitTier{Burst: 100}
+	assert.Contains(t, settingsOf(c.Validate().(*ValidationError)), "rate_limit.tiers.partner.refill")
+}
+
+func TestInvalidEnvironmentValuesAreNamed(t *testing.T) {
+	_, _, err := Load(nil, getenv(map[string]string{"SEARCH_CACHE_TTL": "soon"}))
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "SEARCH_CACHE_TTL")
+}
+
+func TestPrintedConfigRedactsSecrets(t *testing.T) {
+	c := Default()
+	c.Store.MySQLConnection = "root:s3cret@tcp(mysql:3306)/kittens"
+	c.Admin.Token = "t0ken"
+	c.RateLimit.Tiers["partner"] = RateLimitTier{Burst: 100, Refill: 50, APIKeys: "k3y"}
+
+	s := c.String()
+
+	assert.NotContains(t, s, "s3cret")
+	assert.NotContains(t, s, "t0ken")
+	assert.NotContains(t, s, "k3y")
+	assert.Equal(t, "k3y", c.RateLimit.Tiers["partner"].APIKeys)
+	assert.Contains(t, s, "root:REDACTED@tcp(mysql:3306)/kittens")
+	assert.Equal(t, "root:s3cret@tcp(mysql:3306)/kittens", c.Store.MySQLConnection)
+}
+
+func settingsOf(e *ValidationError) []string {
+	var settings []string
+	for _, p := range e.Problems {
+		settings = append(settings, strings.SplitN(p, ":", 2)[0])
+	}
+
+	return settings
+}
+
+func getenv(env map[string]string) func(string) string {
+	if env["MYSQL_CONNECTION"] == "" {
+		env["MYSQL_CONNECTION"] = "root:password@tcp(127.0.0.1:3306)/kittens"
+	}
+
+	return func(name string) string {
+		return env[name]
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "config")
+	assert.Nil(t, err)
+
+	path := filepath.Join(dir, name)
+	assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+
+	return path
+}
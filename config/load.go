@@ -0,0 +1,235 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is a config value which can be overridden by an environment
+// variable and a command line flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"address", "SEARCH_ADDRESS", "the host and port to listen on", func(c *Config) interface{} { return &c.Address }},
+	{"log-level", "SEARCH_LOG_LEVEL", "the minimum level of messages which are logged", func(c *Config) interface{} { return &c.LogLevel }},
+	{"statsd", "SEARCH_STATSD_ADDRESS", "the host and port of the statsd agent", func(c *Config) interface{} { return &c.Statsd.Address }},
+	{"statsd-namespace", "SEARCH_STATSD_NAMESPACE", "the prefix of every metric", func(c *Config) interface{} { return &c.Statsd.Namespace }},
+	{"store", "SEARCH_STORE", "the kitten store, mysql or file", func(c *Config) interface{} { return &c.Store.Kind }},
+	{"mysql", "MYSQL_CONNECTION", "the MySQL connection string", func(c *Config) interface{} { return &c.Store.MySQLConnection }},
+	{"data-dir", "SEARCH_DATA_DIR", "the directory of the file store", func(c *Config) interface{} { return &c.Store.DataDir }},
+	{"fsync", "SEARCH_FSYNC", "when the file store syncs its log, always, interval or never", func(c *Config) interface{} { return &c.Store.Fsync }},
+	{"fsync-interval", "SEARCH_FSYNC_INTERVAL", "how often the file store syncs with the interval policy", func(c *Config) interface{} { return &c.Store.FsyncInterval }},
+	{"store-timeout", "SEARCH_STORE_TIMEOUT", "the time allowed for each call to the mysql store", func(c *Config) interface{} { return &c.Store.Timeout }},
+	{"store-import-timeout", "SEARCH_STORE_IMPORT_TIMEOUT", "the time allowed for each import into the mysql store", func(c *Config) interface{} { return &c.Store.ImportTimeout }},
+	{"store-retries", "SEARCH_STORE_RETRIES", "how many times failed reads of the mysql store are retried", func(c *Config) interface{} { return &c.Store.Retries }},
+	{"store-backoff", "SEARCH_STORE_BACKOFF", "the delay before the first retry of the mysql store", func(c *Config) interface{} { return &c.Store.Backoff }},
+	{"store-max-backoff", "SEARCH_STORE_MAX_BACKOFF", "the longest delay between retries of the mysql store", func(c *Config) interface{} { return &c.Store.MaxBackoff }},
+	{"breaker-threshold", "SEARCH_BREAKER_THRESHOLD", "the consecutive mysql failures which open the circuit breaker", func(c *Config) interface{} { return &c.Store.BreakerThreshold }},
+	{"breaker-timeout", "SEARCH_BREAKER_TIMEOUT", "how long the open circuit breaker refuses mysql calls", func(c *Config) interface{} { return &c.Store.BreakerTimeout }},
+	{"read-timeout", "SEARCH_READ_TIMEOUT", "the time allowed to read a request", func(c *Config) interface{} { return &c.Server.ReadTimeout }},
+	{"write-timeout", "SEARCH_WRITE_TIMEOUT", "the time allowed to write a response", func(c *Config) interface{} { return &c.Server.WriteTimeout }},
+	{"idle-timeout", "SEARCH_IDLE_TIMEOUT", "how long idle keep-alive connections are kept open", func(c *Config) interface{} { return &c.Server.IdleTimeout }},
+	{"shutdown-timeout", "SEARCH_SHUTDOWN_TIMEOUT", "how long requests are given to complete on shutdown", func(c *Config) interface{} { return &c.Server.ShutdownTimeout }},
+	{"boosts", "SEARCH_BOOSTS", `field boosts for ranking, e.g. "name:3,id:0.5"`, func(c *Config) interface{} { return &c.Search.Boosts }},
+	{"reload-interval", "SEARCH_RELOAD_INTERVAL", "how often the index is rebuilt from the store", func(c *Config) interface{} { return &c.Search.ReloadInterval }},
+	{"load-timeout", "SEARCH_LOAD_TIMEOUT", "the time allowed to load every kitten from the store", func(c *Config) interface{} { return &c.Search.LoadTimeout }},
+	{"cache-entries", "SEARCH_CACHE_ENTRIES", "the largest number of cached search results", func(c *Config) interface{} { return &c.Cache.MaxEntries }},
+	{"cache-bytes", "SEARCH_CACHE_BYTES", "the approximate size limit of the search cache", func(c *Config) interface{} { return &c.Cache.MaxBytes }},
+	{"cache-ttl", "SEARCH_CACHE_TTL", "how long search results are cached", func(c *Config) interface{} { return &c.Cache.TTL }},
+	{"cache-negative-ttl", "SEARCH_CACHE_NEGATIVE_TTL", "how long searches without hits are cached", func(c *Config) interface{} { return &c.Cache.NegativeTTL }},
+	{"snapshot-entries", "SEARCH_SNAPSHOT_ENTRIES", "the last good search results kept for when the store is unavailable", func(c *Config) interface{} { return &c.Cache.SnapshotEntries }},
+	{"auth-disabled", "SEARCH_AUTH_DISABLED", "serve requests without authenticating them", func(c *Config) interface{} { return &c.Auth.Disabled }},
+	{"auth-public-key", "SEARCH_AUTH_PUBLIC_KEY", "a PEM public key which bearer tokens are verified with", func(c *Config) interface{} { return &c.Auth.PublicKey }},
+	{"auth-jwks", "SEARCH_AUTH_JWKS", "a JWKS file of the keys which bearer tokens are verified with", func(c *Config) interface{} { return &c.Auth.JWKS }},
+	{"auth-issuer", "SEARCH_AUTH_ISSUER", "the required iss claim of bearer tokens", func(c *Config) interface{} { return &c.Auth.Issuer }},
+	{"auth-audience", "SEARCH_AUTH_AUDIENCE", "the required aud claim of bearer tokens", func(c *Config) interface{} { return &c.Auth.Audience }},
+	{"auth-leeway", "SEARCH_AUTH_LEEWAY", "the clock skew allowed when checking token expiry", func(c *Config) interface{} { return &c.Auth.Leeway }},
+	{"auth-jwks-refresh", "SEARCH_AUTH_JWKS_REFRESH", "how often the JWKS file is checked for changes", func(c *Config) interface{} { return &c.Auth.JWKSRefresh }},
+	{"admin-token", "SEARCH_ADMIN_TOKEN", "the bearer token of the admin endpoints, which are disabled without one", func(c *Config) interface{} { return &c.Admin.Token }},
+}
+
+// Load builds the config from the defaults, the file named by the -config
+// flag or SEARCH_CONFIG, the environment and the flags in args, it returns
+// the arguments remaining after the flags. getenv is usually os.Getenv.
+func Load(args []string, getenv func(string) string) (Config, []string, error) {
+	c := Default()
+
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	path := flags.String("config", getenv("SEARCH_CONFIG"), "a JSON config file (SEARCH_CONFIG)")
+
+	overrides := make(map[string]string)
+	for _, s := range settings {
+		_, boolean := s.value(&c).(*bool)
+		flags.Var(&flagValue{name: s.flag, overrides: overrides, boolean: boolean}, s.flag, fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return c, nil, err
+	}
+
+	if *path != "" {
+		if err := LoadFile(*path, &c); err != nil {
+			return c, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := set(s.value(&c), v); err != nil {
+				return c, nil, fmt.Errorf("invalid %s: %v", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := overrides[s.flag]; ok {
+			if err := set(s.value(&c), v); err != nil {
+				return c, nil, fmt.Errorf("invalid -%s: %v", s.flag, err)
+			}
+		}
+	}
+
+	return c, flags.Args(), c.Validate()
+}
+
+// LoadFile overrides the settings in c with those in the JSON file at path
+func LoadFile(path string, c *Config) error {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		return fmt.Errorf("config file %s: YAML is not supported, use a JSON config file", path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	if unknown := unknownKeys(values, known(), ""); len(unknown) > 0 {
+		return fmt.Errorf("config file %s: unknown settings %s", path, strings.Join(unknown, ", "))
+	}
+
+	// the values are decoded again once they are known to hold only
+	// settings of the Config type
+	b, err = json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// known returns the settings of a Config as a tree of maps
+func known() map[string]interface{} {
+	b, _ := json.Marshal(Default())
+
+	var values map[string]interface{}
+	json.Unmarshal(b, &values)
+
+	return values
+}
+
+// freeForm are the settings which are maps with keys chosen by the user
+var freeForm = map[string]bool{
+	"auth.policy":      true,
+	"auth.roles":       true,
+	"rate_limit.tiers": true,
+}
+
+// unknownKeys returns the path of every key in values which is not in known
+func unknownKeys(values, known map[string]interface{}, prefix string) []string {
+	var unknown []string
+	for k, v := range values {
+		expected, ok := known[k]
+		if !ok {
+			unknown = append(unknown, prefix+k)
+			continue
+		}
+
+		section, isSection := expected.(map[string]interface{})
+		if nested, ok := v.(map[string]interface{}); ok && isSection && !freeForm[prefix+k] {
+			unknown = append(unknown, unknownKeys(nested, section, prefix+k+".")...)
+		}
+	}
+
+	sort.Strings(unknown)
+	return unknown
+}
+
+// set parses s into the setting pointed to by v
+func set(v interface{}, s string) error {
+	switch v := v.(type) {
+	case *string:
+		*v = s
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+
+		*v = b
+	case *int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+
+		*v = n
+	case *Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+
+		*v = Duration(d)
+	default:
+		return fmt.Errorf("unsupported setting type %T", v)
+	}
+
+	return nil
+}
+
+// flagValue records the value of a flag so that flags can be applied after
+// the config file and environment
+type flagValue struct {
+	name      string
+	overrides map[string]string
+	boolean   bool
+}
+
+func (f *flagValue) String() string {
+	if f.overrides == nil {
+		return ""
+	}
+
+	return f.overrides[f.name]
+}
+
+// IsBoolFlag allows boolean settings to be given as a flag without a value
+func (f *flagValue) IsBoolFlag() bool {
+	return f.boolean
+}
+
+func (f *flagValue) Set(s string) error {
+	f.overrides[f.name] = s
+	return nil
+}
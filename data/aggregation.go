@@ -0,0 +1,218 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Types of aggregation which can be computed over the hits of a query
+const (
+	// AggregationHistogram counts hits in fixed width buckets of weight
+	AggregationHistogram = "histogram"
+	// AggregationTerms counts hits for the most common values of a field
+	AggregationTerms = "terms"
+	// AggregationStats returns the count, min, max, average and sum of weight
+	AggregationStats = "stats"
+)
+
+const (
+	// DefaultAggregationSize is the number of terms buckets returned when an
+	// aggregation does not set a size
+	DefaultAggregationSize = 10
+	// MaxAggregationSize is the largest number of terms buckets returned
+	MaxAggregationSize = 100
+	// MaxHistogramBuckets is the largest number of histogram buckets
+	// returned, a narrow interval over a wide range of weights is truncated
+	// to the lowest buckets and the result is marked as truncated
+	MaxHistogramBuckets = 1000
+)
+
+// Aggregation describes a summary computed over every hit of a query
+type Aggregation struct {
+	// Name identifies the aggregation in the results
+	Name string `json:"name"`
+	// Type is one of histogram, terms or stats
+	Type string `json:"type"`
+	// Field is weight for histogram and stats aggregations and name or id
+	// for terms aggregations
+	Field string `json:"field"`
+	// Interval is the width of each histogram bucket
+	Interval float64 `json:"interval,omitempty"`
+	// Size is the number of terms buckets to return, when it is omitted or 0
+	// DefaultAggregationSize buckets are returned
+	Size int `json:"size,omitempty"`
+}
+
+// Validate returns an error describing why the aggregation can not be
+// computed
+func (a Aggregation) Validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("aggregations must have a name")
+	}
+
+	switch a.Type {
+	case AggregationHistogram:
+		if a.Field != SortWeight {
+			return fmt.Errorf("aggregation %s: histograms are only supported on weight", a.Name)
+		}
+
+		if a.Interval <= 0 {
+			return fmt.Errorf("aggregation %s: interval must be greater than zero", a.Name)
+		}
+	case AggregationTerms:
+		if a.Field != SortName && a.Field != SortId {
+			return fmt.Errorf("aggregation %s: terms are only supported on name or id", a.Name)
+		}
+
+		if a.Size < 0 || a.Size > MaxAggregationSize {
+			return fmt.Errorf("aggregation %s: size must be between 1 and %d, or omitted for %d", a.Name, MaxAggregationSize, DefaultAggregationSize)
+		}
+	case AggregationStats:
+		if a.Field != SortWeight {
+			return fmt.Errorf("aggregation %s: stats are only supported on weight", a.Name)
+		}
+	default:
+		return fmt.Errorf("aggregation %s: unknown type %q, expected histogram, terms or stats", a.Name, a.Type)
+	}
+
+	return nil
+}
+
+func (a Aggregation) size() int {
+	if a.Size <= 0 {
+		return DefaultAggregationSize
+	}
+
+	return a.Size
+}
+
+// AggregationResult is the outcome of an Aggregation, Buckets are set for
+// histogram and terms aggregations and Stats for stats aggregations.
+// Truncated is set when a histogram had more than MaxHistogramBuckets
+// buckets and only the lowest were returned.
+type AggregationResult struct {
+	Buckets   []Bucket `json:"buckets,omitempty"`
+	Stats     *Stats   `json:"stats,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+}
+
+// Bucket is the number of hits with a key, for histograms the key is the
+// lower bound of the bucket
+type Bucket struct {
+	Key   interface{} `json:"key"`
+	Count int         `json:"count"`
+}
+
+// Stats summarises the weight of the hits
+type Stats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Sum   float64 `json:"sum"`
+}
+
+// Aggregate computes the aggregations over hits, it is used by stores which
+// hold every match in memory
+func Aggregate(hits []Hit, aggregations []Aggregation) map[string]AggregationResult {
+	if len(aggregations) == 0 {
+		return nil
+	}
+
+	results := make(map[string]AggregationResult)
+	for _, a := range aggregations {
+		switch a.Type {
+		case AggregationHistogram:
+			results[a.Name] = histogram(hits, a.Interval)
+		case AggregationTerms:
+			results[a.Name] = terms(hits, a.Field, a.size())
+		case AggregationStats:
+			results[a.Name] = stats(hits)
+		}
+	}
+
+	return results
+}
+
+func histogram(hits []Hit, interval float64) AggregationResult {
+	counts := make(map[float64]int)
+	for _, h := range hits {
+		counts[math.Floor(float64(h.Weight)/interval)*interval]++
+	}
+
+	keys := make([]float64, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Float64s(keys)
+
+	result := AggregationResult{Buckets: []Bucket{}}
+	if len(keys) > MaxHistogramBuckets {
+		keys = keys[:MaxHistogramBuckets]
+		result.Truncated = true
+	}
+
+	for _, key := range keys {
+		result.Buckets = append(result.Buckets, Bucket{Key: key, Count: counts[key]})
+	}
+
+	return result
+}
+
+func terms(hits []Hit, field string, size int) AggregationResult {
+	counts := make(map[string]int)
+	for _, h := range hits {
+		if field == SortId {
+			counts[h.Id]++
+		} else {
+			counts[h.Name]++
+		}
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	// the most common terms first, ties are ordered by the term
+	sort.Slice(keys, func(a, b int) bool {
+		if counts[keys[a]] != counts[keys[b]] {
+			return counts[keys[a]] > counts[keys[b]]
+		}
+
+		return keys[a] < keys[b]
+	})
+
+	if len(keys) > size {
+		keys = keys[:size]
+	}
+
+	result := AggregationResult{Buckets: []Bucket{}}
+	for _, key := range keys {
+		result.Buckets = append(result.Buckets, Bucket{Key: key, Count: counts[key]})
+	}
+
+	return result
+}
+
+func stats(hits []Hit) AggregationResult {
+	s := &Stats{Count: len(hits)}
+	for i, h := range hits {
+		w := float64(h.Weight)
+		if i == 0 || w < s.Min {
+			s.Min = w
+		}
+		if i == 0 || w > s.Max {
+			s.Max = w
+		}
+
+		s.Sum += w
+	}
+
+	if s.Count > 0 {
+		s.Avg = s.Sum / float64(s.Count)
+	}
+
+	return AggregationResult{Stats: s}
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var aggregationHits = []Hit{
+	{Kitten: Kitten{Id: "1", Name: "Felix", Weight: 12}},
+	{Kitten: Kitten{Id: "2", Name: "Felix", Weight: 18}},
+	{Kitten: Kitten{Id: "3", Name: "Garfield", Weight: 35}},
+}
+
+func TestAggregateComputesHistogramBuckets(t *testing.T) {
+	results := Aggregate(aggregationHits, []Aggregation{{Name: "weights", Type: AggregationHistogram, Field: SortWeight, Interval: 10}})
+
+	assert.Equal(t, []Bucket{{Key: 10.0, Count: 2}, {Key: 30.0, Count: 1}}, results["weights"].Buckets)
+}
+
+func TestAggregateComputesTermsBuckets(t *testing.T) {
+	results := Aggregate(aggregationHits, []Aggregation{{Name: "names", Type: AggregationTerms, Field: SortName, Size: 1}})
+
+	assert.Equal(t, []Bucket{{Key: "Felix", Count: 2}}, results["names"].Buckets)
+}
+
+func TestAggregateComputesStats(t *testing.T) {
+	results := Aggregate(aggregationHits, []Aggregation{{Name: "weight", Type: AggregationStats, Field: SortWeight}})
+
+	assert.Equal(t, &Stats{Count: 3, Min: 12, Max: 35, Avg: 65.0 / 3, Sum: 65}, results["weight"].Stats)
+}
+
+func TestAggregationValidateRejectsUnsupportedFields(t *testing.T) {
+	assert.NotNil(t, Aggregation{Name: "a", Type: AggregationHistogram, Field: SortName, Interval: 1}.Validate())
+	assert.NotNil(t, Aggregation{Name: "a", Type: AggregationTerms, Field: SortWeight}.Validate())
+	assert.NotNil(t, Aggregation{Name: "a", Type: "percentiles", Field: SortWeight}.Validate())
+	assert.Nil(t, Aggregation{Name: "a", Type: AggregationStats, Field: SortWeight}.Validate())
+}
+
+func TestAggregateCapsHistogramBuckets(t *testing.T) {
+	hits := make([]Hit, MaxHistogramBuckets+1)
+	for i := range hits {
+		hits[i] = Hit{Kitten: Kitten{Id: "1", Weight: float32(i)}}
+	}
+
+	results := Aggregate(hits, []Aggregation{{Name: "weights", Type: AggregationHistogram, Field: SortWeight, Interval: 1}})
+
+	assert.Len(t, results["weights"].Buckets, MaxHistogramBuckets)
+	assert.Equal(t, 0.0, results["weights"].Buckets[0].Key)
+	assert.True(t, results["weights"].Truncated)
+
+	results = Aggregate(hits[:MaxHistogramBuckets], []Aggregation{{Name: "weights", Type: AggregationHistogram, Field: SortWeight, Interval: 1}})
+
+	assert.Len(t, results["weights"].Buckets, MaxHistogramBuckets)
+	assert.False(t, results["weights"].Truncated)
+}
@@ -0,0 +1,32 @@
+// Package analysis turns kitten text into the terms used by the search
+// index and query engines
+package analysis
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits text into lower case terms suitable for indexing and
+// querying. Terms are runs of letters and digits, apostrophes inside a word
+// are dropped and the possessive "'s" is removed so that "Freddy's" and
+// "Freddy" produce the same term.
+func Tokenize(text string) []string {
+	var terms []string
+
+	for _, word := range strings.FieldsFunc(text, isSeparator) {
+		word = strings.ToLower(word)
+		word = strings.TrimSuffix(word, "'s")
+		word = strings.Replace(word, "'", "", -1)
+
+		if len(word) > 0 {
+			terms = append(terms, word)
+		}
+	}
+
+	return terms
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+}
@@ -0,0 +1,13 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenizeLowercasesAndRemovesPossessives(t *testing.T) {
+	terms := Tokenize("Fat Freddy's Cat")
+
+	assert.Equal(t, []string{"fat", "freddy", "cat"}, terms)
+}
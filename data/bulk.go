@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"sort"
+)
+
+// ImportBatchSize is the number of kittens written by each multi-row insert
+// during an import
+const ImportBatchSize = 500
+
+// ImportOptions controls how Import treats kittens which already exist
+type ImportOptions struct {
+	// Upsert replaces existing kittens instead of rejecting them
+	Upsert bool
+}
+
+// RowError describes why a single kitten in an import was not written
+type RowError struct {
+	// Row is the position of the kitten in the slice passed to Import
+	Row   int    `json:"row"`
+	Id    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportResult reports the outcome of an import
+type ImportResult struct {
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Errors  []RowError `json:"errors"`
+	// Kittens holds every kitten which was written along with its version
+	Kittens []Kitten `json:"-"`
+}
+
+// Importer is implemented by stores which can write many kittens at once.
+// Kittens which are invalid, repeat an earlier id or, unless upserting,
+// already exist are reported in the result's Errors and the remaining
+// kittens are written in a single transaction, an error means nothing was
+// written.
+type Importer interface {
+	Import(ctx context.Context, kittens []Kitten, options ImportOptions) (ImportResult, error)
+}
+
+// prepareImport returns the positions of the kittens which may be written
+// along with the errors for those which are invalid or repeat an earlier id
+func prepareImport(kittens []Kitten) ([]int, []RowError) {
+	var rows []int
+	var errors []RowError
+
+	seen := make(map[string]bool)
+	for row, k := range kittens {
+		if err := k.Validate(); err != nil {
+			errors = append(errors, RowError{Row: row, Id: k.Id, Error: err.Error()})
+			continue
+		}
+
+		if seen[k.Id] {
+			errors = append(errors, RowError{Row: row, Id: k.Id, Error: "duplicate id in import"})
+			continue
+		}
+
+		seen[k.Id] = true
+		rows = append(rows, row)
+	}
+
+	return rows, errors
+}
+
+// sortRowErrors orders errors by their position in the import
+func sortRowErrors(errors []RowError) {
+	sort.Slice(errors, func(a, b int) bool { return errors[a].Row < errors[b].Row })
+}
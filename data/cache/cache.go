@@ -0,0 +1,332 @@
+// Package cache provides a data.Store which caches the results of searches
+// made against another store, and a Snapshot of the last good results which
+// is served when the store is unavailable.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+// Options control the size of a Cache and how long results are kept
+type Options struct {
+	// MaxEntries is the largest number of results held, the least recently
+	// used result is evicted when it is exceeded
+	MaxEntries int
+	// MaxBytes bounds the approximate size of the results held, it is
+	// measured as the length of their JSON encoding
+	MaxBytes int
+	// TTL is how long a result with hits is served from the cache
+	TTL time.Duration
+	// NegativeTTL is how long a result without any hits is served from the
+	// cache, it is usually shorter than TTL so that a new kitten is found
+	// soon after it is added
+	NegativeTTL time.Duration
+}
+
+// DefaultOptions returns the options used by the search service
+func DefaultOptions() Options {
+	return Options{
+		MaxEntries:  10000,
+		MaxBytes:    64 << 20,
+		TTL:         30 * time.Second,
+		NegativeTTL: 5 * time.Second,
+	}
+}
+
+// searchTimeout bounds a search of the underlying store, the search is
+// shared by every caller asking the same query so it is not bounded by the
+// context of any one of them
+const searchTimeout = 10 * time.Second
+
+type entry struct {
+	key     string
+	result  data.Result
+	size    int
+	expires time.Time
+}
+
+// call is a search which is being executed on behalf of every caller asking
+// the same query, the callers wait on done and then read result and err
+type call struct {
+	done   chan struct{}
+	result data.Result
+	err    error
+}
+
+// Cache is a data.Store which serves repeated searches from an LRU cache of
+// the results returned by the underlying store. Concurrent identical
+// searches which miss the cache are coalesced into a single search of the
+// underlying store. Errors are never cached and cached results are shared
+// between callers so they must not be modified.
+//
+// Cache implements data.Listener, every write removes all cached results as
+// any of them may include the kitten which changed.
+type Cache struct {
+	store   data.Store
+	options Options
+	statsd  *statsd.Client
+	now     func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	bytes    int
+	inflight map[string]*call
+	// generation is incremented by every invalidation, a search which
+	// started in an earlier generation may have read stale data so its
+	// result is not cached
+	generation int
+	// snapshot remembers every result fetched from the underlying store
+	snapshot *Snapshot
+}
+
+// Search returns the cached result for q when there is one, otherwise it
+// searches the underlying store
+func (c *Cache) Search(ctx context.Context, q data.Query) (data.Result, error) {
+	k, err := key(q)
+	if err != nil {
+		return data.Result{}, err
+	}
+
+	c.mu.Lock()
+	if r, ok := c.get(k); ok {
+		c.mu.Unlock()
+		c.statsd.Incr("search.cache.hit", nil, 1)
+		return r, nil
+	}
+
+	if cl, ok := c.inflight[k]; ok {
+		c.mu.Unlock()
+		c.statsd.Incr("search.cache.coalesced", nil, 1)
+		return c.wait(ctx, cl)
+	}
+
+	cl := &call{done: make(chan struct{})}
+	c.inflight[k] = cl
+	generation := c.generation
+	c.mu.Unlock()
+
+	c.statsd.Incr("search.cache.miss", nil, 1)
+	go c.search(k, q, cl, generation)
+
+	return c.wait(ctx, cl)
+}
+
+// search runs the search cl of q against the underlying store and caches its
+// result when no write happened since generation. It is detached from the
+// callers so that the caller which started it leaving does not fail the
+// others waiting for it.
+func (c *Cache) search(k string, q data.Query, cl *call, generation int) {
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+	defer cancel()
+
+	cl.result, cl.err = c.store.Search(ctx, q)
+
+	c.mu.Lock()
+	if c.inflight[k] == cl {
+		delete(c.inflight, k)
+	}
+	if cl.err == nil && generation == c.generation {
+		c.add(k, cl.result)
+	}
+	snapshot := c.snapshot
+	c.mu.Unlock()
+
+	if cl.err == nil && snapshot != nil {
+		snapshot.Remember(q, cl.result)
+	}
+
+	close(cl.done)
+}
+
+// wait returns the outcome of a search shared with other callers, or the
+// error from ctx if it finishes first
+func (c *Cache) wait(ctx context.Context, cl *call) (data.Result, error) {
+	select {
+	case <-cl.done:
+		return cl.result, cl.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return data.Result{}, data.ErrTimeout
+		}
+
+		return data.Result{}, ctx.Err()
+	}
+}
+
+// All returns every kitten held by the underlying store, it is not cached
+func (c *Cache) All(ctx context.Context) ([]data.Kitten, error) {
+	return c.store.All(ctx)
+}
+
+// Put invalidates the cache as k may now match cached queries
+func (c *Cache) Put(k data.Kitten) {
+	c.Invalidate()
+}
+
+// Remove invalidates the cache as id may be in cached results
+func (c *Cache) Remove(id string) {
+	c.Invalidate()
+}
+
+// Invalidate removes every cached result, searches which are in progress
+// complete but their results are not cached
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	c.reset()
+	c.generation++
+	c.mu.Unlock()
+
+	c.statsd.Incr("search.cache.invalidation", nil, 1)
+}
+
+// SetSnapshot remembers the results of later searches of the underlying
+// store in s, results served from the cache are not remembered again so the
+// age of a remembered result is the time since it was fetched
+func (c *Cache) SetSnapshot(s *Snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.snapshot = s
+}
+
+// SetOptions changes the bounds and TTLs of the cache, entries are evicted
+// if the cache is over its new bounds and cached entries keep the expiry
+// they were given when they were added
+func (c *Cache) SetOptions(options Options) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.options = options
+	c.evict()
+}
+
+// Len returns the number of cached results and their approximate size in
+// bytes
+func (c *Cache) Len() (entries, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len(), c.bytes
+}
+
+func (c *Cache) reset() {
+	c.entries = make(map[string]*list.Element)
+	c.lru = list.New()
+	c.bytes = 0
+	// later callers must not join a search which may return stale data
+	c.inflight = make(map[string]*call)
+}
+
+// get returns the live entry for k and marks it as recently used, the
+// caller holds mu
+func (c *Cache) get(k string) (data.Result, bool) {
+	el, ok := c.entries[k]
+	if !ok {
+		return data.Result{}, false
+	}
+
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.removeElement(el)
+		return data.Result{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	return e.result, true
+}
+
+// add caches r for k, the caller holds mu
+func (c *Cache) add(k string, r data.Result) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	size := len(k) + len(b)
+	if size > c.options.MaxBytes {
+		return
+	}
+
+	ttl := c.options.TTL
+	if r.Total == 0 {
+		ttl = c.options.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	if el, ok := c.entries[k]; ok {
+		c.removeElement(el)
+	}
+
+	c.entries[k] = c.lru.PushFront(&entry{key: k, result: r, size: size, expires: c.now().Add(ttl)})
+	c.bytes += size
+	c.evict()
+}
+
+// evict removes the least recently used entries until the cache is within
+// its bounds, the caller holds mu
+func (c *Cache) evict() {
+	evicted := 0
+	for c.lru.Len() > 0 && (c.lru.Len() > c.options.MaxEntries || c.bytes > c.options.MaxBytes) {
+		c.removeElement(c.lru.Back())
+		evicted++
+	}
+
+	if evicted > 0 {
+		c.statsd.Count("search.cache.eviction", int64(evicted), nil, 1)
+	}
+	c.statsd.Gauge("search.cache.entries", float64(c.lru.Len()), nil, 1)
+	c.statsd.Gauge("search.cache.bytes", float64(c.bytes), nil, 1)
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+}
+
+// key identifies the results of q, a parsed query is keyed by the string
+// form of its syntax tree
+func key(q data.Query) (string, error) {
+	k := struct {
+		Text         string
+		Expr         string
+		Fuzziness    data.Fuzziness
+		Limit        int
+		Offset       int
+		After        *data.Cursor
+		Sort         []data.SortField
+		Aggregations []data.Aggregation
+	}{q.Text, "", q.Fuzziness, q.Limit, q.Offset, q.After, q.Sort, q.Aggregations}
+
+	if q.Expr != nil {
+		k.Text = ""
+		k.Expr = q.Expr.String()
+	}
+
+	b, err := json.Marshal(k)
+	return string(b), err
+}
+
+// New creates a Cache in front of store which reports hits, misses and
+// evictions to statsd
+func New(store data.Store, options Options, statsd *statsd.Client) *Cache {
+	c := &Cache{
+		store:   store,
+		options: options,
+		statsd:  statsd,
+		now:     time.Now,
+	}
+	c.reset()
+
+	return c
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var felix = data.Result{Hits: []data.Hit{{Kitten: data.Kitten{Id: "1", Name: "Felix", Weight: 12.3}}}, Total: 1}
+
+func TestRepeatedSearchesAreServedFromTheCache(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", data.Query{Text: "Felix"}).Return(felix, nil).Once()
+	c := New(store, DefaultOptions(), nil)
+
+	for i := 0; i < 3; i++ {
+		r, err := c.Search(context.Background(), data.Query{Text: "Felix"})
+		assert.Nil(t, err)
+		assert.Equal(t, felix, r)
+	}
+
+	store.AssertExpectations(t)
+}
+
+func TestEntriesExpireAfterTheirTTL(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", data.Query{Text: "Felix"}).Return(felix, nil).Twice()
+	store.On("Search", data.Query{Text: "Rex"}).Return(data.Result{}, nil).Twice()
+	c, clock := newCache(store, DefaultOptions())
+
+	c.Search(context.Background(), data.Query{Text: "Felix"})
+	c.Search(context.Background(), data.Query{Text: "Rex"})
+
+	// results without hits have a shorter TTL
+	clock.advance(DefaultOptions().NegativeTTL)
+	c.Search(context.Background(), data.Query{Text: "Felix"})
+	c.Search(context.Background(), data.Query{Text: "Rex"})
+
+	clock.advance(DefaultOptions().TTL)
+	c.Search(context.Background(), data.Query{Text: "Felix"})
+
+	store.AssertExpectations(t)
+}
+
+func TestLeastRecentlyUsedEntriesAreEvicted(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", mock.Anything).Return(felix, nil)
+	options := DefaultOptions()
+	options.MaxEntries = 2
+	c := New(store, options, nil)
+
+	c.Search(context.Background(), data.Query{Text: "a"})
+	c.Search(context.Background(), data.Query{Text: "b"})
+	c.Search(context.Background(), data.Query{Text: "a"})
+	c.Search(context.Background(), data.Query{Text: "c"})
+	c.Search(context.Background(), data.Query{Text: "a"})
+	c.Search(context.Background(), data.Query{Text: "b"})
+
+	store.AssertNumberOfCalls(t, "Search", 4)
+	entries, _ := c.Len()
+	assert.Equal(t, 2, entries)
+}
+
+func TestEntriesAreEvictedToStayWithinMaxBytes(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", mock.Anything).Return(felix, nil)
+	c := New(store, DefaultOptions(), nil)
+
+	c.Search(context.Background(), data.Query{Text: "a"})
+	_, size := c.Len()
+
+	options := DefaultOptions()
+	options.MaxBytes = size*2 + 1
+	c = New(store, options, nil)
+
+	c.Search(context.Background(), data.Query{Text: "a"})
+	c.Search(context.Background(), data.Query{Text: "b"})
+	c.Search(context.Background(), data.Query{Text: "c"})
+
+	entries, bytes := c.Len()
+	assert.Equal(t, 2, entries)
+	assert.True(t, bytes <= options.MaxBytes)
+}
+
+func TestShrinkingTheCacheEvictsEntries(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", mock.Anything).Return(felix, nil)
+	c := New(store, DefaultOptions(), nil)
+
+	c.Search(context.Background(), data.Query{Text: "a"})
+	c.Search(context.Background(), data.Query{Text: "b"})
+	c.Search(context.Background(), data.Query{Text: "c"})
+
+	options := DefaultOptions()
+	options.MaxEntries = 1
+	c.SetOptions(options)
+
+	entries, _ := c.Len()
+	assert.Equal(t, 1, entries)
+
+	// the most recently used entry is kept
+	c.Search(context.Background(), data.Query{Text: "c"})
+	store.AssertNumberOfCalls(t, "Search", 3)
+}
+
+func TestWritesInvalidateTheCache(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", data.Query{Text: "Felix"}).Return(felix, nil).Twice()
+	c := New(store, DefaultOptions(), nil)
+
+	c.Search(context.Background(), data.Query{Text: "Felix"})
+	c.Put(data.Kitten{Id: "2", Name: "Felix"})
+	c.Search(context.Background(), data.Query{Text: "Felix"})
+
+	store.AssertExpectations(t)
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", data.Query{Text: "Felix"}).Return(data.Result{}, data.ErrUnavailable).Once()
+	store.On("Search", data.Query{Text: "Felix"}).Return(felix, nil).Once()
+	c := New(store, DefaultOptions(), nil)
+
+	_, err := c.Search(context.Background(), data.Query{Text: "Felix"})
+	assert.Equal(t, data.ErrUnavailable, err)
+
+	r, err := c.Search(context.Background(), data.Query{Text: "Felix"})
+	assert.Nil(t, err)
+	assert.Equal(t, felix, r)
+}
+
+func TestConcurrentIdenticalSearchesAreCoalesced(t *testing.T) {
+	release := make(chan struct{})
+	store := &blockingStore{release: release}
+	c := New(store, DefaultOptions(), nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := c.Search(context.Background(), data.Query{Text: "Felix"})
+			assert.Nil(t, err)
+			assert.Equal(t, felix, r)
+		}()
+	}
+
+	// let every caller reach the cache before the search completes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, store.calls())
+}
+
+func TestCoalescedSearchesSurviveTheFirstCallerLeaving(t *testing.T) {
+	release := make(chan struct{})
+	store := &blockingStore{release: release}
+	c := New(store, DefaultOptions(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.Search(ctx, data.Query{Text: "Felix"})
+		first <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	second := make(chan data.Result)
+	go func() {
+		r, _ := c.Search(context.Background(), data.Query{Text: "Felix"})
+		second <- r
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-first)
+
+	close(release)
+	assert.Equal(t, felix, <-second)
+	assert.Equal(t, 1, store.calls())
+}
+
+func TestASearchStartedBeforeAWriteIsNotCached(t *testing.T) {
+	release := make(chan struct{})
+	store := &blockingStore{release: release}
+	c := New(store, DefaultOptions(), nil)
+
+	done := make(chan struct{})
+	go func() {
+		c.Search(context.Background(), data.Query{Text: "Felix"})
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	c.Remove("1")
+	close(release)
+	<-done
+
+	entries, _ := c.Len()
+	assert.Equal(t, 0, entries)
+}
+
+func TestKeysDistinguishQueries(t *testing.T) {
+	a, _ := key(data.Query{Text: "Felix", Limit: 10})
+	b, _ := key(data.Query{Text: "Felix", Limit: 10, Offset: 10})
+	c, _ := key(data.Query{Text: "Felix", Limit: 10, Fuzziness: data.FuzzinessAuto})
+
+	assert.NotEqual(t, a, b)
+	assert.NotEqual(t, a, c)
+	assert.NotEqual(t, b, c)
+}
+
+// blockingStore returns felix from every search once release is closed
+type blockingStore struct {
+	release chan struct{}
+	mu      sync.Mutex
+	n       int
+}
+
+func (s *blockingStore) Search(ctx context.Context, q data.Query) (data.Result, error) {
+	s.mu.Lock()
+	s.n++
+	s.mu.Unlock()
+
+	select {
+	case <-s.release:
+		return felix, nil
+	case <-ctx.Done():
+		return data.Result{}, ctx.Err()
+	}
+}
+
+func (s *blockingStore) All(ctx context.Context) ([]data.Kitten, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *blockingStore) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.n
+}
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newCache(store data.Store, options Options) (*Cache, *clock) {
+	clock := &clock{t: time.Now()}
+	c := New(store, options, nil)
+	c.now = clock.now
+
+	return c, clock
+}
+
+func TestOnlyResultsFetchedFromTheStoreAreRemembered(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("Search", data.Query{Text: "felix"}).Return(data.Result{Total: 1}, nil).Once()
+
+	c, clock := newCache(store, DefaultOptions())
+	s := NewSnapshot(10, nil)
+	s.now = clock.now
+	c.SetSnapshot(s)
+
+	c.Search(context.Background(), data.Query{Text: "felix"})
+	fetched := clock.now()
+
+	// a cached result does not make the remembered result look newer
+	clock.advance(time.Second)
+	r, err := c.Search(context.Background(), data.Query{Text: "felix"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, r.Total)
+
+	r, stored, ok := s.Recall(data.Query{Text: "felix"})
+	assert.True(t, ok)
+	assert.Equal(t, 1, r.Total)
+	assert.Equal(t, fetched, stored)
+	store.AssertExpectations(t)
+}
+
+func TestSnapshotKeepsTheMostRecentlyStoredResults(t *testing.T) {
+	s := NewSnapshot(2, nil)
+	for _, text := range []string{"felix", "tom", "garfield"} {
+		s.Remember(data.Query{Text: text}, data.Result{Total: len(text)})
+	}
+
+	_, _, ok := s.Recall(data.Query{Text: "felix"})
+	assert.False(t, ok)
+
+	r, _, ok := s.Recall(data.Query{Text: "garfield"})
+	assert.True(t, ok)
+	assert.Equal(t, 8, r.Total)
+
+	s.SetMaxEntries(0)
+	_, _, ok = s.Recall(data.Query{Text: "garfield"})
+	assert.False(t, ok)
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+type snapshotEntry struct {
+	key    string
+	result data.Result
+	stored time.Time
+}
+
+// Snapshot keeps the last good result of recent searches so that they can
+// still be answered, with stale results, when the store is unavailable.
+// Unlike a Cache its results never expire and are not invalidated by writes,
+// the least recently stored result is evicted when the snapshot is full.
+type Snapshot struct {
+	statsd *statsd.Client
+	now    func() time.Time
+
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+}
+
+// Remember stores r as the last good result of q
+func (s *Snapshot) Remember(q data.Query, r data.Result) {
+	k, err := key(q)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[k]; ok {
+		s.lru.Remove(el)
+	}
+
+	s.entries[k] = s.lru.PushFront(&snapshotEntry{key: k, result: r, stored: s.now()})
+	s.evict()
+}
+
+// Recall returns the last good result of q and when it was stored, ok is
+// false when q has not been remembered
+func (s *Snapshot) Recall(q data.Query) (r data.Result, stored time.Time, ok bool) {
+	k, err := key(q)
+	if err != nil {
+		return data.Result{}, time.Time{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[k]
+	if !ok {
+		return data.Result{}, time.Time{}, false
+	}
+
+	e := el.Value.(*snapshotEntry)
+	return e.result, e.stored, true
+}
+
+// SetMaxEntries changes the number of results kept, a snapshot of 0
+// entries keeps nothing
+func (s *Snapshot) SetMaxEntries(maxEntries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxEntries = maxEntries
+	s.evict()
+}
+
+// evict removes the oldest results until the snapshot is within its bound,
+// the caller holds mu
+func (s *Snapshot) evict() {
+	for s.lru.Len() > 0 && s.lru.Len() > s.maxEntries {
+		e := s.lru.Remove(s.lru.Back()).(*snapshotEntry)
+		delete(s.entries, e.key)
+	}
+
+	s.statsd.Gauge("search.snapshot.entries", float64(s.lru.Len()), nil, 1)
+}
+
+// NewSnapshot creates an empty Snapshot which keeps up to maxEntries results
+func NewSnapshot(maxEntries int, statsd *statsd.Client) *Snapshot {
+	return &Snapshot{
+		statsd:     statsd,
+		now:        time.Now,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
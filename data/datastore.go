@@ -1,6 +1,48 @@
 package data
 
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUnavailable is returned when the backend datastore can not be
+	// reached or fails while executing a request
+	ErrUnavailable = errors.New("datastore unavailable")
+	// ErrTimeout is returned when a request to the datastore does not
+	// complete before its context deadline
+	ErrTimeout = errors.New("datastore request timed out")
+)
+
+// InvalidQueryError is returned when a store is given a query which it can
+// not execute
+type InvalidQueryError struct {
+	Err error
+}
+
+func (e *InvalidQueryError) Error() string {
+	return e.Err.Error()
+}
+
 // Store is an interface used for interacting with the backend datastore
 type Store interface {
-	Search(name string) []Kitten
+	// Search returns a page of the kittens matching q, errors are ErrNotFound,
+	// ErrUnavailable, ErrTimeout or an *InvalidQueryError
+	Search(ctx context.Context, q Query) (Result, error)
+	// All returns every kitten held by the store, it is used to bulk load
+	// secondary stores such as the search index
+	All(ctx context.Context) ([]Kitten, error)
+}
+
+// contextError returns the error describing why ctx has finished, or nil
+// when it is still active
+func contextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+
+	return ctx.Err()
 }
@@ -0,0 +1,626 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logFile      = "kittens.log"
+	snapshotFile = "kittens.snapshot"
+	lockFile     = "LOCK"
+)
+
+// SyncPolicy controls when a FileStore flushes its log to stable storage
+type SyncPolicy string
+
+const (
+	// SyncAlways syncs the log before every write returns, a write which
+	// has returned survives a power failure
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval syncs the log every FileStoreOptions.SyncInterval, a
+	// power failure loses at most the writes made during the interval
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system, writes survive the
+	// process crashing but not the machine
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy returns the SyncPolicy named s
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown sync policy %q, policies are always, interval and never", s)
+}
+
+// FileStoreOptions configures a FileStore
+type FileStoreOptions struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// CompactAfter is the number of log records after which the log is
+	// folded into a new snapshot
+	CompactAfter int
+}
+
+// DefaultFileStoreOptions syncs every write and compacts after 10000 records
+func DefaultFileStoreOptions() FileStoreOptions {
+	return FileStoreOptions{
+		Sync:         SyncAlways,
+		SyncInterval: time.Second,
+		CompactAfter: 10000,
+	}
+}
+
+// logRecord is a single entry in the log, every kitten in Put is stored
+// with the version it holds, each record is applied completely or not at all
+type logRecord struct {
+	Put    []Kitten `json:"put,omitempty"`
+	Delete string   `json:"delete,omitempty"`
+}
+
+// appendLog is the file the log is appended to
+type appendLog interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FileStore is an embedded datastore which persists kittens to a directory.
+// Every write is appended to a log before it is applied in memory, the log
+// is periodically compacted into a snapshot and on startup the snapshot and
+// log are replayed. A record torn by a crash at the end of the log is
+// discarded. The directory is locked so that a single process at a time can
+// open the store.
+type FileStore struct {
+	memory *MemoryStore
+
+	// mu serializes writes so that a write is checked against, logged and
+	// applied to the same state
+	mu      sync.Mutex
+	dir     string
+	lock    *os.File
+	log     appendLog
+	size    int64
+	options FileStoreOptions
+	records int
+	dirty   bool
+	// failed is true once a failed record could not be cut off the log,
+	// further writes are refused as they would follow it
+	failed bool
+	done   chan struct{}
+	closed bool
+}
+
+// Search returns a page of the kittens matching q
+func (f *FileStore) Search(ctx context.Context, q Query) (Result, error) {
+	return f.memory.Search(ctx, q)
+}
+
+// All returns every kitten in the store
+func (f *FileStore) All(ctx context.Context) ([]Kitten, error) {
+	return f.memory.All(ctx)
+}
+
+// Get returns the kitten with id
+func (f *FileStore) Get(ctx context.Context, id string) (Kitten, error) {
+	return f.memory.Get(ctx, id)
+}
+
+// Create logs and adds a new kitten
+func (f *FileStore) Create(ctx context.Context, k Kitten) (Kitten, error) {
+	if err := contextError(ctx); err != nil {
+		return Kitten{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.memory.lookup(k.Id); ok {
+		return Kitten{}, ErrExists
+	}
+
+	k.Version = 1
+	if err := f.append(logRecord{Put: []Kitten{k}}); err != nil {
+		return Kitten{}, err
+	}
+
+	f.memory.putAll(k)
+	f.maybeCompact()
+
+	return k, nil
+}
+
+// Update logs and replaces an existing kitten when it is at the expected
+// version
+func (f *FileStore) Update(ctx context.Context, k Kitten, version int64) (Kitten, error) {
+	if err := contextError(ctx); err != nil {
+		return Kitten{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	current, err := f.current(k.Id, version)
+	if err != nil {
+		return Kitten{}, err
+	}
+
+	k.Version = current.Version + 1
+	if err := f.append(logRecord{Put: []Kitten{k}}); err != nil {
+		return Kitten{}, err
+	}
+
+	f.memory.putAll(k)
+	f.maybeCompact()
+
+	return k, nil
+}
+
+// Delete logs and removes a kitten when it is at the expected version
+func (f *FileStore) Delete(ctx context.Context, id string, version int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := f.current(id, version); err != nil {
+		return err
+	}
+
+	if err := f.append(logRecord{Delete: id}); err != nil {
+		return err
+	}
+
+	f.memory.removeId(id)
+	f.maybeCompact()
+
+	return nil
+}
+
+// Import writes kittens as a single log record so that a crash during the
+// import leaves either none or all of them
+func (f *FileStore) Import(ctx context.Context, kittens []Kitten, options ImportOptions) (ImportResult, error) {
+	if err := contextError(ctx); err != nil {
+		return ImportResult{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rows, errors := prepareImport(kittens)
+	result := ImportResult{Errors: errors}
+
+	for _, row := range rows {
+		k := kittens[row]
+		k.Version = 1
+
+		current, exists := f.memory.lookup(k.Id)
+		switch {
+		case !exists:
+			result.Created++
+		case options.Upsert:
+			k.Version = current.Version + 1
+			result.Updated++
+		default:
+			result.Errors = append(result.Errors, RowError{Row: row, Id: k.Id, Error: ErrExists.Error()})
+			continue
+		}
+
+		result.Kittens = append(result.Kittens, k)
+	}
+
+	if len(result.Kittens) > 0 {
+		if err := f.append(logRecord{Put: result.Kittens}); err != nil {
+			return ImportResult{}, err
+		}
+
+		f.memory.putAll(result.Kittens...)
+		f.maybeCompact()
+	}
+
+	sortRowErrors(result.Errors)
+	return result, nil
+}
+
+// Compact writes a snapshot of every kitten and truncates the log
+func (f *FileStore) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.compact()
+}
+
+// Close syncs the log and releases the files and the lock held by the store
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+
+	f.closed = true
+	close(f.done)
+	defer f.lock.Close()
+
+	if err := f.log.Sync(); err != nil {
+		f.log.Close()
+		return err
+	}
+
+	return f.log.Close()
+}
+
+// current returns the kitten with id when it is at version, the caller must
+// hold the lock
+func (f *FileStore) current(id string, version int64) (Kitten, error) {
+	k, ok := f.memory.lookup(id)
+	if !ok {
+		return Kitten{}, ErrNotFound
+	}
+
+	if version != AnyVersion && k.Version != version {
+		return Kitten{}, ErrVersionMismatch
+	}
+
+	return k, nil
+}
+
+// append writes r to the log and syncs it according to the sync policy, a
+// record which fails to be written or synced is cut off the log. The caller
+// must hold the lock.
+func (f *FileStore) append(r logRecord) error {
+	if f.closed || f.failed {
+		return ErrUnavailable
+	}
+
+	line, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.log.Write(line); err != nil {
+		log.Println(err)
+		f.rollback()
+		return ErrUnavailable
+	}
+
+	f.dirty = true
+
+	if f.options.Sync == SyncAlways {
+		if err := f.sync(); err != nil {
+			f.rollback()
+			return err
+		}
+	}
+
+	f.size += int64(len(line))
+	f.records++
+
+	return nil
+}
+
+// rollback truncates the log to the end of the last record written, so that
+// a record whose write failed is not replayed on recovery and later records
+// do not follow a partial one. The caller must hold the lock.
+func (f *FileStore) rollback() {
+	if err := f.log.Truncate(f.size); err != nil {
+		log.Println("Unable to remove a failed record from the log, refusing further writes:", err)
+		f.failed = true
+	}
+}
+
+// maybeCompact compacts the log once it holds CompactAfter records, it is
+// called after a logged write has been applied so that the snapshot
+// includes it. The caller must hold the lock.
+func (f *FileStore) maybeCompact() {
+	if f.options.CompactAfter <= 0 || f.records < f.options.CompactAfter {
+		return
+	}
+
+	// the write is already in the log so a failed compaction is only
+	// logged and is retried after the next write
+	if err := f.compact(); err != nil {
+		log.Println("Unable to compact the log:", err)
+	}
+}
+
+// sync flushes the log to stable storage, the caller must hold the lock
+func (f *FileStore) sync() error {
+	if !f.dirty {
+		return nil
+	}
+
+	if err := f.log.Sync(); err != nil {
+		log.Println(err)
+		return ErrUnavailable
+	}
+
+	f.dirty = false
+	return nil
+}
+
+// compact replaces the snapshot with the current kittens and then empties
+// the log. Records are idempotent so a crash between the two steps replays
+// the log over a snapshot which already contains it. The caller must hold
+// the lock.
+func (f *FileStore) compact() error {
+	kittens, err := f.memory.All(context.Background())
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(kittens)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(f.dir, snapshotFile), b); err != nil {
+		return err
+	}
+
+	if err := f.log.Truncate(0); err != nil {
+		return err
+	}
+
+	f.size = 0
+	if err := f.log.Sync(); err != nil {
+		return err
+	}
+
+	f.records = 0
+	f.dirty = false
+
+	return nil
+}
+
+// background syncs the log when the sync policy is SyncInterval
+func (f *FileStore) background() {
+	ticker := time.NewTicker(f.options.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			f.mu.Lock()
+			if !f.closed {
+				f.sync()
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+// encodeRecord returns r as a log line, the JSON is prefixed with its CRC-32
+// so that a torn or corrupted line is detected during recovery
+func encodeRecord(r logRecord) ([]byte, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, 0, len(body)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(body))...)
+	line = append(line, body...)
+
+	return append(line, '\n'), nil
+}
+
+// decodeRecord parses a log line without its trailing newline
+func decodeRecord(line []byte) (logRecord, error) {
+	r := logRecord{}
+
+	if len(line) < 10 || line[8] != ' ' {
+		return r, fmt.Errorf("malformed record")
+	}
+
+	sum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE(line[9:]) {
+		return r, fmt.Errorf("checksum mismatch")
+	}
+
+	err = json.Unmarshal(line[9:], &r)
+	return r, err
+}
+
+// recoverStore replays the snapshot and log in dir into memory, a damaged record
+// at the end of the log is the result of a crash during a write and is cut
+// off, damage anywhere else is an error. It returns the number of records in
+// the log.
+func recoverStore(dir string, memory *MemoryStore) (int, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	if err == nil {
+		var kittens []Kitten
+		if err := json.Unmarshal(b, &kittens); err != nil {
+			return 0, fmt.Errorf("corrupt snapshot: %v", err)
+		}
+
+		memory.putAll(kittens...)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	records := 0
+	var offset int64
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return records, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		// a line without a newline was cut short by a crash, a damaged line
+		// may only be the result of a crash if nothing follows it
+		record, decodeErr := decodeRecord(bytes.TrimSuffix(line, []byte("\n")))
+		torn := err == io.EOF
+		if !torn && decodeErr != nil {
+			if _, peekErr := r.Peek(1); peekErr != io.EOF {
+				return 0, fmt.Errorf("corrupt log record at offset %d: %v", offset, decodeErr)
+			}
+
+			torn = true
+		}
+
+		if torn {
+			log.Printf("Discarding incomplete log record at offset %d", offset)
+			if err := file.Truncate(offset); err != nil {
+				return 0, err
+			}
+
+			return records, file.Sync()
+		}
+
+		memory.putAll(record.Put...)
+		if record.Delete != "" {
+			memory.removeId(record.Delete)
+		}
+
+		records++
+		offset += int64(len(line))
+	}
+}
+
+// writeFileSync atomically replaces path with b once b is on stable storage
+func writeFileSync(path string, b []byte) error {
+	temp := path + ".tmp"
+
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+
+	// the rename is only durable once the directory is synced
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// errLocked is returned by tryLock when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// lockDir takes an exclusive lock on dir, it fails when another process such
+// as kittenctl has the store open. The lock is released when the returned
+// file is closed or the process exits.
+func lockDir(dir string) (*os.File, error) {
+	file, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tryLock(file); err != nil {
+		file.Close()
+		if err == errLocked {
+			return nil, fmt.Errorf("the file store in %s is in use by another process", dir)
+		}
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// OpenFileStore opens or creates a FileStore in dir, recovering any kittens
+// written by a previous process
+func OpenFileStore(dir string, options FileStoreOptions) (*FileStore, error) {
+	if options.Sync == SyncInterval && options.SyncInterval <= 0 {
+		return nil, fmt.Errorf("the sync interval must be greater than zero")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	memory := NewMemoryStore()
+	records, err := recoverStore(dir, memory)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	f := &FileStore{
+		memory:  memory,
+		dir:     dir,
+		lock:    lock,
+		log:     file,
+		size:    info.Size(),
+		options: options,
+		records: records,
+		done:    make(chan struct{}),
+	}
+
+	if options.Sync == SyncInterval {
+		go f.background()
+	}
+
+	return f, nil
+}
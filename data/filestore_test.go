@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStoreRecoversWritesAfterReopening(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openFileStore(t, dir, DefaultFileStoreOptions())
+	store.Create(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 12.3})
+	store.Create(context.Background(), Kitten{Id: "2", Name: "Tom", Weight: 15})
+	store.Update(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 13}, 1)
+	store.Delete(context.Background(), "2", AnyVersion)
+	assert.Nil(t, store.Close())
+
+	store = openFileStore(t, dir, DefaultFileStoreOptions())
+	defer store.Close()
+
+	k, err := store.Get(context.Background(), "1")
+	assert.Nil(t, err)
+	assert.Equal(t, Kitten{Id: "1", Name: "Felix", Weight: 13, Version: 2}, k)
+
+	_, err = store.Get(context.Background(), "2")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, 1, len(search(t, store, Query{Text: "felix"}).Hits))
+}
+
+func TestFileStoreDiscardsARecordTornByACrash(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openFileStore(t, dir, DefaultFileStoreOptions())
+	store.Create(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 12.3})
+	store.Close()
+
+	f, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`0badc0de {"put":[{"Id":"2"`)
+	f.Close()
+
+	store = openFileStore(t, dir, DefaultFileStoreOptions())
+	kittens, _ := store.All(context.Background())
+	assert.Equal(t, 1, len(kittens))
+
+	// the log is usable again once the torn record is cut off
+	_, err := store.Create(context.Background(), Kitten{Id: "2", Name: "Tom", Weight: 15})
+	assert.Nil(t, err)
+	store.Close()
+
+	store = openFileStore(t, dir, DefaultFileStoreOptions())
+	defer store.Close()
+
+	kittens, _ = store.All(context.Background())
+	assert.Equal(t, 2, len(kittens))
+}
+
+func TestFileStoreRefusesToOpenACorruptLog(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openFileStore(t, dir, DefaultFileStoreOptions())
+	store.Create(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 12.3})
+	store.Create(context.Background(), Kitten{Id: "2", Name: "Tom", Weight: 15})
+	store.Close()
+
+	b, _ := ioutil.ReadFile(filepath.Join(dir, logFile))
+	b[20] = 'X'
+	ioutil.WriteFile(filepath.Join(dir, logFile), b, 0644)
+
+	_, err := OpenFileStore(dir, DefaultFileStoreOptions())
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreCompactsTheLogIntoASnapshot(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	options := DefaultFileStoreOptions()
+	options.Sync = SyncNever
+	options.CompactAfter = 2
+
+	store := openFileStore(t, dir, options)
+	store.Create(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 12.3})
+	store.Create(context.Background(), Kitten{Id: "2", Name: "Tom", Weight: 15})
+	store.Create(context.Background(), Kitten{Id: "3", Name: "Garfield", Weight: 35})
+	store.Close()
+
+	info, err := os.Stat(filepath.Join(dir, snapshotFile))
+	assert.Nil(t, err)
+	assert.True(t, info.Size() > 0)
+
+	store = openFileStore(t, dir, options)
+	defer store.Close()
+
+	kittens, _ := store.All(context.Background())
+	assert.Equal(t, 3, len(kittens))
+	assert.Equal(t, 1, store.records)
+}
+
+// failingLog fails every Sync after writing
+type failingLog struct {
+	*os.File
+}
+
+func (l failingLog) Sync() error {
+	return errors.New("input/output error")
+}
+
+func TestFileStoreCutsOffARecordWhichFailedToSync(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openFileStore(t, dir, DefaultFileStoreOptions())
+	store.Create(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 12.3})
+
+	file := store.log.(*os.File)
+	store.log = failingLog{file}
+	_, err := store.Create(context.Background(), Kitten{Id: "2", Name: "Tom", Weight: 15})
+	assert.Equal(t, ErrUnavailable, err)
+	store.log = file
+
+	_, err = store.Create(context.Background(), Kitten{Id: "3", Name: "Garfield", Weight: 20})
+	assert.Nil(t, err)
+	store.Close()
+
+	store = openFileStore(t, dir, DefaultFileStoreOptions())
+	defer store.Close()
+
+	_, err = store.Get(context.Background(), "2")
+	assert.Equal(t, ErrNotFound, err)
+	kittens, _ := store.All(context.Background())
+	assert.Equal(t, 2, len(kittens))
+}
+
+func TestFileStoreLocksItsDirectory(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	store := openFileStore(t, dir, DefaultFileStoreOptions())
+
+	_, err := OpenFileStore(dir, DefaultFileStoreOptions())
+	assert.NotNil(t, err)
+
+	store.Close()
+	store = openFileStore(t, dir, DefaultFileStoreOptions())
+	store.Close()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filestore")
+	assert.Nil(t, err)
+
+	return dir
+}
+
+func openFileStore(t *testing.T, dir string, options FileStoreOptions) *FileStore {
+	store, err := OpenFileStore(dir, options)
+	assert.Nil(t, err)
+
+	return store
+}
@@ -0,0 +1,71 @@
+package fuzzy
+
+// Distance returns the Damerau-Levenshtein distance between a and b, the
+// number of insertions, deletions, substitutions and transpositions of
+// adjacent characters needed to turn one into the other
+func Distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	if len(s) == 0 {
+		return len(t)
+	}
+	if len(t) == 0 {
+		return len(s)
+	}
+
+	// lastRow holds the last row in which each character was seen in s
+	lastRow := make(map[rune]int)
+	infinity := len(s) + len(t)
+
+	// d is offset by one row and column to hold the infinity sentinel
+	d := make([][]int, len(s)+2)
+	for i := range d {
+		d[i] = make([]int, len(t)+2)
+	}
+
+	d[0][0] = infinity
+	for i := 0; i <= len(s); i++ {
+		d[i+1][0] = infinity
+		d[i+1][1] = i
+	}
+	for j := 0; j <= len(t); j++ {
+		d[0][j+1] = infinity
+		d[1][j+1] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		lastColumn := 0
+
+		for j := 1; j <= len(t); j++ {
+			k := lastRow[t[j-1]]
+			l := lastColumn
+
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+				lastColumn = j
+			}
+
+			d[i+1][j+1] = min(
+				d[i][j]+cost,
+				d[i+1][j]+1,
+				d[i][j+1]+1,
+				d[k][l]+(i-k-1)+1+(j-l-1),
+			)
+		}
+
+		lastRow[s[i-1]] = i
+	}
+
+	return d[len(s)+1][len(t)+1]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,33 @@
+package fuzzy
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistanceCountsTranspositionsAsOneEdit(t *testing.T) {
+	assert.Equal(t, 1, Distance("garfeild", "garfield"))
+}
+
+func TestDistanceCountsInsertionsDeletionsAndSubstitutions(t *testing.T) {
+	assert.Equal(t, 0, Distance("felix", "felix"))
+	assert.Equal(t, 1, Distance("felix", "feliz"))
+	assert.Equal(t, 1, Distance("felix", "felx"))
+	assert.Equal(t, 3, Distance("kitten", "sitting"))
+	assert.Equal(t, 5, Distance("", "felix"))
+}
+
+func TestTreeSearchReturnsTermsWithinDistance(t *testing.T) {
+	tree := NewTree("felix", "garfield", "tom", "freddy", "felix")
+
+	matches := tree.Search("felx", 1)
+	assert.Equal(t, []string{"felix"}, matches)
+
+	matches = tree.Search("tim", 2)
+	sort.Strings(matches)
+	assert.Equal(t, []string{"tom"}, matches)
+
+	assert.Equal(t, 4, tree.Len())
+}
@@ -0,0 +1,89 @@
+package fuzzy
+
+// Tree is a BK-tree of terms which finds every term within a given edit
+// distance of a query without comparing the query against every term
+type Tree struct {
+	root *node
+	size int
+}
+
+type node struct {
+	term     string
+	children map[int]*node
+}
+
+// NewTree creates a Tree containing terms
+func NewTree(terms ...string) *Tree {
+	t := &Tree{}
+	for _, term := range terms {
+		t.Add(term)
+	}
+
+	return t
+}
+
+// Add inserts term into the tree, adding a term which is already present
+// has no effect
+func (t *Tree) Add(term string) {
+	if t.root == nil {
+		t.root = &node{term: term}
+		t.size++
+		return
+	}
+
+	n := t.root
+	for {
+		d := Distance(term, n.term)
+		if d == 0 {
+			return
+		}
+
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*node)
+			}
+
+			n.children[d] = &node{term: term}
+			t.size++
+			return
+		}
+
+		n = child
+	}
+}
+
+// Len returns the number of terms in the tree
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// Search returns every term in the tree within max edits of term
+func (t *Tree) Search(term string, max int) []string {
+	if t.root == nil {
+		return nil
+	}
+
+	var matches []string
+	candidates := []*node{t.root}
+
+	for len(candidates) > 0 {
+		n := candidates[len(candidates)-1]
+		candidates = candidates[:len(candidates)-1]
+
+		d := Distance(term, n.term)
+		if d <= max {
+			matches = append(matches, n.term)
+		}
+
+		// by the triangle inequality only children whose distance from this
+		// node is within max of d can contain matches
+		for cd, child := range n.children {
+			if cd >= d-max && cd <= d+max {
+				candidates = append(candidates, child)
+			}
+		}
+	}
+
+	return matches
+}
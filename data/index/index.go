@@ -0,0 +1,345 @@
+package index
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/analysis"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/fuzzy"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
+)
+
+// fields maps the name of every indexed text field to the function which
+// extracts its text from a Kitten
+var fields = map[string]func(k data.Kitten) string{
+	query.FieldId:   func(k data.Kitten) string { return k.Id },
+	query.FieldName: func(k data.Kitten) string { return k.Name },
+}
+
+// posting records the occurrences of a term within a single document
+type posting struct {
+	doc       int
+	frequency int
+	positions []int
+}
+
+// fieldIndex is the term dictionary for a single field, every term maps to
+// a postings list ordered by document number
+type fieldIndex struct {
+	postings map[string][]posting
+	lengths  []int
+	total    int
+}
+
+// Index is an in memory inverted index over kittens which implements
+// data.Store and data.Listener. Documents which are replaced or removed are
+// marked as deleted and only discarded when the index is reloaded.
+//
+// Searches return data.ErrUnavailable while the index may be missing writes
+// made through other instances, that is when its last reload failed or its
+// contents are older than the max age.
+type Index struct {
+	mu       sync.RWMutex
+	docs     []data.Kitten
+	deleted  []bool
+	ids      map[string]int
+	fields   map[string]*fieldIndex
+	terms    *fuzzy.Tree
+	byWeight []int
+	ranking  Ranking
+
+	loaded time.Time
+	failed bool
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// New creates an empty Index which ranks results with DefaultRanking
+func New() *Index {
+	i := &Index{ranking: DefaultRanking(), now: time.Now}
+	i.reset()
+	i.loaded = i.now()
+
+	return i
+}
+
+// Load creates an Index containing every kitten held by source
+func Load(ctx context.Context, source data.Store) (*Index, error) {
+	kittens, err := source.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	i := New()
+	i.Add(kittens...)
+
+	return i, nil
+}
+
+// Reload rebuilds the index from source and atomically replaces the current
+// contents, searches running during the rebuild see the previous contents
+// and the current contents are kept when source returns an error
+func (i *Index) Reload(ctx context.Context, source data.Store) error {
+	fresh, err := Load(ctx, source)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.failed = err != nil
+	if err != nil {
+		return err
+	}
+
+	i.loaded = i.now()
+	i.docs = fresh.docs
+	i.deleted = fresh.deleted
+	i.ids = fresh.ids
+	i.fields = fresh.fields
+	i.terms = fresh.terms
+	i.byWeight = fresh.byWeight
+
+	return nil
+}
+
+// Add indexes the given kittens, replacing any kitten with the same id
+func (i *Index) Add(kittens ...data.Kitten) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, k := range kittens {
+		i.insert(k)
+	}
+
+	i.byWeight = make([]int, len(i.docs))
+	for doc := range i.docs {
+		i.byWeight[doc] = doc
+	}
+
+	sort.SliceStable(i.byWeight, func(a, b int) bool {
+		return i.docs[i.byWeight[a]].Weight < i.docs[i.byWeight[b]].Weight
+	})
+}
+
+// Put indexes a single kitten replacing any kitten with the same id, unlike
+// Add it does not re-sort the whole index
+func (i *Index) Put(k data.Kitten) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	doc := i.insert(k)
+
+	n := sort.Search(len(i.byWeight), func(n int) bool {
+		return i.docs[i.byWeight[n]].Weight > k.Weight
+	})
+
+	i.byWeight = append(i.byWeight, 0)
+	copy(i.byWeight[n+1:], i.byWeight[n:])
+	i.byWeight[n] = doc
+}
+
+// Remove deletes the kitten with id from the index
+func (i *Index) Remove(id string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if doc, ok := i.ids[id]; ok {
+		i.deleted[doc] = true
+		delete(i.ids, id)
+	}
+}
+
+// insert adds k as a new document, the caller must hold the write lock
+func (i *Index) insert(k data.Kitten) int {
+	if previous, ok := i.ids[k.Id]; ok {
+		i.deleted[previous] = true
+	}
+
+	doc := len(i.docs)
+	i.docs = append(i.docs, k)
+	i.deleted = append(i.deleted, false)
+	i.ids[k.Id] = doc
+
+	for name, extract := range fields {
+		terms := analysis.Tokenize(extract(k))
+		i.fields[name].add(doc, terms)
+
+		for _, term := range terms {
+			i.terms.Add(term)
+		}
+	}
+
+	return doc
+}
+
+// SetRanking replaces the parameters used to score search results
+func (i *Index) SetRanking(r Ranking) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.ranking = r
+}
+
+// SetMaxAge sets how old the contents may be before searches are refused,
+// a max age of 0 allows contents of any age
+func (i *Index) SetMaxAge(maxAge time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.maxAge = maxAge
+}
+
+// available returns data.ErrUnavailable when the contents may be out of
+// date, the caller holds mu
+func (i *Index) available() error {
+	if i.failed || i.maxAge > 0 && i.now().Sub(i.loaded) > i.maxAge {
+		return data.ErrUnavailable
+	}
+
+	return nil
+}
+
+// Len returns the number of kittens in the index
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.ids)
+}
+
+// All returns every kitten in the index
+func (i *Index) All(ctx context.Context) ([]data.Kitten, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	kittens := make([]data.Kitten, 0, len(i.ids))
+	for doc, k := range i.docs {
+		if !i.deleted[doc] {
+			kittens = append(kittens, k)
+		}
+	}
+
+	return kittens, nil
+}
+
+func (i *Index) reset() {
+	i.docs = nil
+	i.deleted = nil
+	i.ids = make(map[string]int)
+	i.fields = make(map[string]*fieldIndex)
+	i.terms = fuzzy.NewTree()
+	i.byWeight = nil
+	for name := range fields {
+		i.fields[name] = &fieldIndex{postings: make(map[string][]posting)}
+	}
+}
+
+// match returns the ordered set of documents which contain term, or a term
+// within distance edits of it, in any of the named fields along with the
+// score of each document
+func (i *Index) match(term string, names []string, distance int) ([]int, map[int]float64) {
+	candidates := []string{term}
+	if distance > 0 {
+		candidates = i.terms.Search(term, distance)
+	}
+
+	var docs []int
+	scores := make(map[int]float64)
+
+	for _, candidate := range candidates {
+		// the closer a candidate is to the query term the more it contributes
+		weight := 1 / float64(1+fuzzy.Distance(term, candidate))
+		candidateScores := make(map[int]float64)
+
+		for _, name := range names {
+			f := i.fields[name]
+			list := f.postings[candidate]
+			boost := i.ranking.boost(name)
+
+			for _, p := range list {
+				candidateScores[p.doc] += weight * boost * i.ranking.score(f, len(i.docs), len(list), p.frequency, f.lengths[p.doc])
+			}
+
+			docs = union(docs, f.docs(candidate))
+		}
+
+		// a document is scored by the best candidate it contains
+		for doc, score := range candidateScores {
+			if score > scores[doc] {
+				scores[doc] = score
+			}
+		}
+	}
+
+	return docs, scores
+}
+
+func (f *fieldIndex) add(doc int, terms []string) {
+	for position, term := range terms {
+		list := f.postings[term]
+		if n := len(list); n > 0 && list[n-1].doc == doc {
+			list[n-1].frequency++
+			list[n-1].positions = append(list[n-1].positions, position)
+			continue
+		}
+
+		f.postings[term] = append(list, posting{doc: doc, frequency: 1, positions: []int{position}})
+	}
+
+	f.lengths = append(f.lengths, len(terms))
+	f.total += len(terms)
+}
+
+func (f *fieldIndex) docs(term string) []int {
+	list := f.postings[term]
+	docs := make([]int, len(list))
+	for n, p := range list {
+		docs[n] = p.doc
+	}
+
+	return docs
+}
+
+// intersect returns the documents present in both of the ordered lists a and b
+func intersect(a, b []int) []int {
+	var out []int
+	for x, y := 0, 0; x < len(a) && y < len(b); {
+		switch {
+		case a[x] < b[y]:
+			x++
+		case a[x] > b[y]:
+			y++
+		default:
+			out = append(out, a[x])
+			x++
+			y++
+		}
+	}
+
+	return out
+}
+
+// union returns the documents present in either of the ordered lists a and b
+func union(a, b []int) []int {
+	out := make([]int, 0, len(a)+len(b))
+	x, y := 0, 0
+	for x < len(a) && y < len(b) {
+		switch {
+		case a[x] < b[y]:
+			out = append(out, a[x])
+			x++
+		case a[x] > b[y]:
+			out = append(out, b[y])
+			y++
+		default:
+			out = append(out, a[x])
+			x++
+			y++
+		}
+	}
+
+	out = append(out, a[x:]...)
+	return append(out, b[y:]...)
+}
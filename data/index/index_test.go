@@ -0,0 +1,216 @@
+package index
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadIndexesEveryKittenInTheSource(t *testing.T) {
+	index := load(t)
+
+	assert.Equal(t, 3, index.Len())
+}
+
+func TestSearchMatchesIndividualTermsCaseInsensitively(t *testing.T) {
+	index := load(t)
+	kittens := search(t, index, data.Query{Text: "garfield"}).Hits
+
+	assert.Equal(t, 1, len(kittens))
+	assert.Equal(t, "Garfield", kittens[0].Name)
+}
+
+func TestSearchRequiresEveryTerm(t *testing.T) {
+	index := load(t)
+
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "Fat Freddy"}).Hits))
+	assert.Equal(t, 0, len(search(t, index, data.Query{Text: "Fat Garfield"}).Hits))
+}
+
+func TestReloadReplacesContents(t *testing.T) {
+	index := New()
+	index.Add(data.Kitten{Id: "4", Name: "Tom"})
+
+	assert.Nil(t, index.Reload(context.Background(), &data.MemoryStore{}))
+
+	assert.Equal(t, 0, len(search(t, index, data.Query{Text: "Tom"}).Hits))
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "Felix"}).Hits))
+}
+
+func TestSearchRanksBetterMatchesFirst(t *testing.T) {
+	index := New()
+	index.Add(
+		data.Kitten{Id: "1", Name: "Cat Burglar"},
+		data.Kitten{Id: "2", Name: "Cat"},
+	)
+
+	hits := search(t, index, data.Query{Text: "cat"}).Hits
+
+	assert.Equal(t, 2, len(hits))
+	assert.Equal(t, "Cat", hits[0].Name)
+	assert.True(t, hits[0].Score > hits[1].Score)
+}
+
+func TestSearchAppliesFieldBoosts(t *testing.T) {
+	index := New()
+	index.Add(
+		data.Kitten{Id: "felix", Name: "Tom"},
+		data.Kitten{Id: "2", Name: "Felix"},
+	)
+
+	index.SetRanking(Ranking{K1: 1.2, B: 0.75, Boosts: map[string]float64{"id": 10}})
+	hits := search(t, index, data.Query{Text: "felix"}).Hits
+
+	assert.Equal(t, "Tom", hits[0].Name)
+}
+
+func TestParseBoostsRejectsUnknownFields(t *testing.T) {
+	_, err := ParseBoosts("colour:2")
+
+	assert.NotNil(t, err)
+}
+
+func TestSearchToleratesTyposWithFuzziness(t *testing.T) {
+	index := load(t)
+
+	assert.Equal(t, 0, len(search(t, index, data.Query{Text: "Garfeild"}).Hits))
+
+	hits := search(t, index, data.Query{Text: "Garfeild", Fuzziness: data.FuzzinessAuto}).Hits
+	assert.Equal(t, 1, len(hits))
+	assert.Equal(t, "Garfield", hits[0].Name)
+}
+
+func TestSearchScoresExactMatchesAboveFuzzyMatches(t *testing.T) {
+	index := New()
+	index.Add(
+		data.Kitten{Id: "1", Name: "Tim"},
+		data.Kitten{Id: "2", Name: "Tom"},
+	)
+
+	hits := search(t, index, data.Query{Text: "tom", Fuzziness: 1}).Hits
+
+	assert.Equal(t, 2, len(hits))
+	assert.Equal(t, "Tom", hits[0].Name)
+}
+
+func TestSearchSupportsBooleanOperators(t *testing.T) {
+	index := load(t)
+
+	assert.Equal(t, 2, len(search(t, index, data.Query{Text: "felix OR garfield"}).Hits))
+	assert.Equal(t, 2, len(search(t, index, data.Query{Text: "NOT felix"}).Hits))
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "cat NOT garfield"}).Hits))
+}
+
+func TestSearchMatchesPhrasesInOrder(t *testing.T) {
+	index := load(t)
+
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: `name:"freddy's cat"`}).Hits))
+	assert.Equal(t, 0, len(search(t, index, data.Query{Text: `"cat freddy"`}).Hits))
+}
+
+func TestSearchFiltersByWeightRange(t *testing.T) {
+	index := load(t)
+
+	hits := search(t, index, data.Query{Text: "weight:[10 TO 25]"}).Hits
+	assert.Equal(t, 2, len(hits))
+
+	hits = search(t, index, data.Query{Text: "weight:{12.3 TO *]"}).Hits
+	assert.Equal(t, 2, len(hits))
+
+	hits = search(t, index, data.Query{Text: "weight:12.3"}).Hits
+	assert.Equal(t, "Felix", hits[0].Name)
+}
+
+func TestSearchAggregatesEveryMatchNotJustThePage(t *testing.T) {
+	index := load(t)
+
+	result := search(t, index, data.Query{
+		Text:         "NOT tom",
+		Limit:        1,
+		Aggregations: []data.Aggregation{{Name: "weight", Type: data.AggregationStats, Field: data.SortWeight}},
+	})
+
+	assert.Equal(t, 1, len(result.Hits))
+	assert.Equal(t, 3, result.Aggregations["weight"].Stats.Count)
+}
+
+func TestPutReplacesAndRemoveDeletesKittens(t *testing.T) {
+	index := load(t)
+
+	index.Put(data.Kitten{Id: "3", Name: "Garfield the Great", Weight: 5})
+	index.Put(data.Kitten{Id: "4", Name: "Tom", Weight: 15})
+	index.Remove("1")
+
+	assert.Equal(t, 3, index.Len())
+	assert.Equal(t, 0, len(search(t, index, data.Query{Text: "felix"}).Hits))
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "garfield"}).Hits))
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "great"}).Hits))
+	assert.Equal(t, 2, len(search(t, index, data.Query{Text: "NOT cat"}).Hits))
+
+	hits := search(t, index, data.Query{Text: "weight:[* TO 16]"}).Hits
+	assert.Equal(t, 2, len(hits))
+}
+
+func TestLoadReturnsTheSourceError(t *testing.T) {
+	source := &data.MockStore{}
+	source.On("All").Return([]data.Kitten(nil), data.ErrUnavailable)
+
+	_, err := Load(context.Background(), source)
+
+	assert.Equal(t, data.ErrUnavailable, err)
+}
+
+func TestReloadKeepsTheContentsWhenTheSourceFails(t *testing.T) {
+	index := load(t)
+	source := &data.MockStore{}
+	source.On("All").Return([]data.Kitten(nil), data.ErrUnavailable)
+
+	err := index.Reload(context.Background(), source)
+
+	assert.Equal(t, data.ErrUnavailable, err)
+	assert.Equal(t, 3, index.Len())
+}
+
+func TestSearchesAreUnavailableUntilAFailedReloadSucceeds(t *testing.T) {
+	index := load(t)
+	failing := &data.MockStore{}
+	failing.On("All").Return([]data.Kitten(nil), data.ErrUnavailable)
+
+	index.Reload(context.Background(), failing)
+	_, err := index.Search(context.Background(), data.Query{Text: "Felix"})
+	assert.Equal(t, data.ErrUnavailable, err)
+
+	assert.Nil(t, index.Reload(context.Background(), &data.MemoryStore{}))
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "Felix"}).Hits))
+}
+
+func TestSearchesAreUnavailableOnceTheContentsAreOlderThanTheMaxAge(t *testing.T) {
+	index := load(t)
+	now := index.loaded
+	index.now = func() time.Time { return now }
+	index.SetMaxAge(time.Minute)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1, len(search(t, index, data.Query{Text: "Felix"}).Hits))
+
+	now = now.Add(time.Second)
+	_, err := index.Search(context.Background(), data.Query{Text: "Felix"})
+	assert.Equal(t, data.ErrUnavailable, err)
+}
+
+func load(t *testing.T) *Index {
+	index, err := Load(context.Background(), &data.MemoryStore{})
+	assert.Nil(t, err)
+
+	return index
+}
+
+func search(t *testing.T, index *Index, q data.Query) data.Result {
+	result, err := index.Search(context.Background(), q)
+	assert.Nil(t, err)
+
+	return result
+}
@@ -0,0 +1,84 @@
+package index
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Ranking holds the parameters used to score documents with BM25
+type Ranking struct {
+	// K1 controls how quickly repeated occurrences of a term saturate
+	K1 float64
+	// B controls how strongly scores are normalized by field length
+	B float64
+	// Boosts multiplies the score of matches in the named field, fields
+	// without a boost are weighted 1
+	Boosts map[string]float64
+}
+
+// DefaultRanking returns the standard BM25 parameters with matches in the
+// name weighted above matches in other fields
+func DefaultRanking() Ranking {
+	return Ranking{
+		K1:     1.2,
+		B:      0.75,
+		Boosts: map[string]float64{"name": 2.0},
+	}
+}
+
+// ParseBoosts parses field boosts in the form "name:2,id:0.5"
+func ParseBoosts(s string) (map[string]float64, error) {
+	boosts := make(map[string]float64)
+
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid boost %q, expected field:weight", pair)
+		}
+
+		field := strings.TrimSpace(parts[0])
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("invalid boost %q, unknown field %s", pair, field)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid boost %q, weight must be a positive number", pair)
+		}
+
+		boosts[field] = weight
+	}
+
+	return boosts, nil
+}
+
+func (r Ranking) boost(field string) float64 {
+	if b, ok := r.Boosts[field]; ok {
+		return b
+	}
+
+	return 1
+}
+
+// score returns the BM25 score of a single term occurring frequency times in
+// a field of the given length
+func (r Ranking) score(f *fieldIndex, docs int, matching int, frequency int, length int) float64 {
+	idf := math.Log(1 + (float64(docs)-float64(matching)+0.5)/(float64(matching)+0.5))
+
+	average := 1.0
+	if docs > 0 && f.total > 0 {
+		average = float64(f.total) / float64(docs)
+	}
+
+	tf := float64(frequency)
+	norm := r.K1 * (1 - r.B + r.B*float64(length)/average)
+
+	return idf * tf * (r.K1 + 1) / (tf + norm)
+}
@@ -0,0 +1,234 @@
+package index
+
+import (
+	"context"
+	"sort"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/analysis"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
+)
+
+// Search executes the query returning a page of the matching kittens ordered
+// by their BM25 score, terms match any term within the query's fuzziness
+func (i *Index) Search(ctx context.Context, q data.Query) (data.Result, error) {
+	n, err := q.Node()
+	if err != nil {
+		return data.Result{}, &data.InvalidQueryError{Err: err}
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if err := i.available(); err != nil {
+		return data.Result{}, err
+	}
+
+	docs, scores := i.eval(n, q.Fuzziness)
+
+	hits := make([]data.Hit, 0, len(docs))
+	for _, doc := range docs {
+		if !i.deleted[doc] {
+			hits = append(hits, data.Hit{Kitten: i.docs[doc], Score: scores[doc]})
+		}
+	}
+
+	return data.Paginate(hits, q), nil
+}
+
+// eval returns the ordered set of documents matching n along with their
+// scores, the scores map may contain documents which did not match
+func (i *Index) eval(n query.Node, fuzziness data.Fuzziness) ([]int, map[int]float64) {
+	switch n := n.(type) {
+	case query.And:
+		var docs []int
+		scores := make(map[int]float64)
+
+		for c, child := range n.Children {
+			childDocs, childScores := i.eval(child, fuzziness)
+			if c == 0 {
+				docs = childDocs
+			} else {
+				docs = intersect(docs, childDocs)
+			}
+
+			add(scores, childScores)
+		}
+
+		return docs, scores
+	case query.Or:
+		var docs []int
+		scores := make(map[int]float64)
+
+		for _, child := range n.Children {
+			childDocs, childScores := i.eval(child, fuzziness)
+			docs = union(docs, childDocs)
+			add(scores, childScores)
+		}
+
+		return docs, scores
+	case query.Not:
+		childDocs, _ := i.eval(n.Child, fuzziness)
+		return difference(i.all(), childDocs), nil
+	case query.Term:
+		terms := analysis.Tokenize(n.Value)
+		if len(terms) == 1 {
+			return i.match(terms[0], fieldNames(n.Field), fuzziness.Distance(terms[0]))
+		}
+
+		// a term such as "abc-123" is split into several terms which must
+		// appear together
+		return i.phrase(terms, fieldNames(n.Field))
+	case query.Phrase:
+		return i.phrase(analysis.Tokenize(n.Value), fieldNames(n.Field))
+	case query.Range:
+		return i.weightRange(n), nil
+	}
+
+	return nil, nil
+}
+
+// phrase returns the documents which contain terms at consecutive positions
+// in any of the named fields
+func (i *Index) phrase(terms []string, names []string) ([]int, map[int]float64) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var docs []int
+	scores := make(map[int]float64)
+
+	for _, name := range names {
+		f := i.fields[name]
+		boost := i.ranking.boost(name)
+
+		candidates := f.docs(terms[0])
+		for _, term := range terms[1:] {
+			candidates = intersect(candidates, f.docs(term))
+		}
+
+		var matched []int
+		for _, doc := range candidates {
+			if !f.consecutive(terms, doc) {
+				continue
+			}
+
+			matched = append(matched, doc)
+			for _, term := range terms {
+				list := f.postings[term]
+				p := f.posting(term, doc)
+				scores[doc] += boost * i.ranking.score(f, len(i.docs), len(list), p.frequency, f.lengths[doc])
+			}
+		}
+
+		docs = union(docs, matched)
+	}
+
+	return docs, scores
+}
+
+// weightRange returns the ordered set of documents whose weight is within r
+func (i *Index) weightRange(r query.Range) []int {
+	r = data.WeightRange(r)
+
+	start := 0
+	if r.Min != nil {
+		start = sort.Search(len(i.byWeight), func(n int) bool {
+			return float64(i.docs[i.byWeight[n]].Weight) >= *r.Min
+		})
+	}
+
+	var docs []int
+	for _, doc := range i.byWeight[start:] {
+		weight := float64(i.docs[doc].Weight)
+		if r.Max != nil && weight > *r.Max {
+			break
+		}
+
+		if r.Contains(weight) {
+			docs = append(docs, doc)
+		}
+	}
+
+	sort.Ints(docs)
+	return docs
+}
+
+// all returns every document in the index
+func (i *Index) all() []int {
+	docs := make([]int, len(i.docs))
+	for doc := range docs {
+		docs[doc] = doc
+	}
+
+	return docs
+}
+
+// posting returns the posting for term in doc
+func (f *fieldIndex) posting(term string, doc int) posting {
+	list := f.postings[term]
+	n := sort.Search(len(list), func(n int) bool { return list[n].doc >= doc })
+	if n < len(list) && list[n].doc == doc {
+		return list[n]
+	}
+
+	return posting{}
+}
+
+// consecutive returns true when terms occur one after another in doc
+func (f *fieldIndex) consecutive(terms []string, doc int) bool {
+	for _, start := range f.posting(terms[0], doc).positions {
+		found := true
+
+		for offset, term := range terms[1:] {
+			if !contains(f.posting(term, doc).positions, start+offset+1) {
+				found = false
+				break
+			}
+		}
+
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fieldNames returns the indexed fields searched for a query field
+func fieldNames(field string) []string {
+	if field == query.FieldAny {
+		return query.TextFields
+	}
+
+	return []string{field}
+}
+
+func add(scores map[int]float64, more map[int]float64) {
+	for doc, score := range more {
+		scores[doc] += score
+	}
+}
+
+func contains(positions []int, position int) bool {
+	n := sort.SearchInts(positions, position)
+	return n < len(positions) && positions[n] == position
+}
+
+// difference returns the documents in the ordered list a which are not in
+// the ordered list b
+func difference(a, b []int) []int {
+	var out []int
+	y := 0
+	for _, doc := range a {
+		for y < len(b) && b[y] < doc {
+			y++
+		}
+
+		if y >= len(b) || b[y] != doc {
+			out = append(out, doc)
+		}
+	}
+
+	return out
+}
@@ -4,4 +4,13 @@ type Kitten struct {
 	Id     string
 	Name   string
 	Weight float32
+	// Version starts at 1 and is incremented every time the kitten is updated
+	Version int64
+}
+
+// Hit is a Kitten matched by a search along with its relevance score, stores
+// which do not rank results return a score of zero
+type Hit struct {
+	Kitten
+	Score float64 `json:"score"`
 }
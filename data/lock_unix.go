@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package data
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an exclusive advisory lock on file without waiting, the lock
+// is released when the file is closed
+func tryLock(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+
+	return err
+}
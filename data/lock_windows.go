@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package data
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// LockFileEx is not exported by the syscall package
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// tryLock takes an exclusive lock on the first byte of file without
+// waiting, the lock is released when the file is closed
+func tryLock(file *os.File) error {
+	overlapped := &syscall.Overlapped{}
+	r, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(overlapped)))
+	if r != 0 {
+		return nil
+	}
+
+	if err == errorLockViolation {
+		return errLocked
+	}
+
+	return err
+}
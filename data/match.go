@@ -0,0 +1,99 @@
+package data
+
+import (
+	"github.com/building-microservices-with-go/chapter10-services-search/data/analysis"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
+)
+
+// matches evaluates the query syntax tree n against a single kitten, terms
+// are expanded to any term in the dictionary within the allowed fuzziness
+func matches(k Kitten, n query.Node, fuzziness Fuzziness, dictionary *termDictionary) bool {
+	switch n := n.(type) {
+	case query.And:
+		for _, child := range n.Children {
+			if !matches(k, child, fuzziness, dictionary) {
+				return false
+			}
+		}
+
+		return true
+	case query.Or:
+		for _, child := range n.Children {
+			if matches(k, child, fuzziness, dictionary) {
+				return true
+			}
+		}
+
+		return false
+	case query.Not:
+		return !matches(k, n.Child, fuzziness, dictionary)
+	case query.Term:
+		terms := analysis.Tokenize(n.Value)
+		if len(terms) != 1 {
+			return matchesPhrase(k, n.Field, terms)
+		}
+
+		candidates := []string{terms[0]}
+		if distance := fuzziness.Distance(terms[0]); distance > 0 {
+			candidates = dictionary.lookup(terms[0], distance)
+		}
+
+		for _, text := range fieldText(k, n.Field) {
+			for _, term := range analysis.Tokenize(text) {
+				for _, candidate := range candidates {
+					if term == candidate {
+						return true
+					}
+				}
+			}
+		}
+
+		return false
+	case query.Phrase:
+		return matchesPhrase(k, n.Field, analysis.Tokenize(n.Value))
+	case query.Range:
+		return WeightRange(n).Contains(float64(k.Weight))
+	}
+
+	return false
+}
+
+// matchesPhrase returns true when terms appear consecutively in field
+func matchesPhrase(k Kitten, field string, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+
+	for _, text := range fieldText(k, field) {
+		tokens := analysis.Tokenize(text)
+
+		for start := 0; start+len(terms) <= len(tokens); start++ {
+			found := true
+			for offset, term := range terms {
+				if tokens[start+offset] != term {
+					found = false
+					break
+				}
+			}
+
+			if found {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// fieldText returns the text of the named field, or of every text field
+// when field is query.FieldAny
+func fieldText(k Kitten, field string) []string {
+	switch field {
+	case query.FieldId:
+		return []string{k.Id}
+	case query.FieldName:
+		return []string{k.Name}
+	}
+
+	return []string{k.Id, k.Name}
+}
@@ -1,36 +1,423 @@
 package data
 
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
 var data = []Kitten{
 	Kitten{
-		Id:     "1",
-		Name:   "Felix",
-		Weight: 12.3,
+		Id:      "1",
+		Name:    "Felix",
+		Weight:  12.3,
+		Version: 1,
 	},
 	Kitten{
-		Id:     "2",
-		Name:   "Fat Freddy's Cat",
-		Weight: 20.0,
+		Id:      "2",
+		Name:    "Fat Freddy's Cat",
+		Weight:  20.0,
+		Version: 1,
 	},
 	Kitten{
-		Id:     "3",
-		Name:   "Garfield",
-		Weight: 35.0,
+		Id:      "3",
+		Name:    "Garfield",
+		Weight:  35.0,
+		Version: 1,
 	},
 }
 
-// MemoryStore is a simple in memory datastore that implements Store
+// MemoryStore is an in memory datastore that implements Store, Writer and
+// Importer and is safe for concurrent use. Kittens are indexed by id and by
+// name. The zero value is seeded with a small set of sample kittens, use
+// NewMemoryStore to choose the kittens it starts with.
 type MemoryStore struct {
+	once sync.Once
+	mu   sync.RWMutex
+	// kittens is the primary index by id and names maps the collation key
+	// of each name to the ordered ids of the kittens with that name
+	kittens map[string]Kitten
+	names   map[string][]string
+	terms   *termDictionary
+	// stale counts the names removed since the term dictionary was built,
+	// their terms are only discarded when the dictionary is rebuilt
+	stale int
 }
 
-//Search returns a slice of Kitten which have a name matching the name in the parameters
-func (m *MemoryStore) Search(name string) []Kitten {
-	var kittens []Kitten
+func (m *MemoryStore) seed() {
+	m.reset(data)
+}
+
+// reset replaces the contents of the store with kittens, the caller must
+// hold the write lock
+func (m *MemoryStore) reset(kittens []Kitten) {
+	m.kittens = make(map[string]Kitten, len(kittens))
+	m.names = make(map[string][]string)
+
+	for _, k := range kittens {
+		m.put(k)
+	}
+
+	m.rebuildTerms()
+}
+
+// put stores k as it is replacing any kitten with the same id, the caller
+// must hold the write lock
+func (m *MemoryStore) put(k Kitten) {
+	if previous, ok := m.kittens[k.Id]; ok {
+		m.remove(previous.Id)
+	}
+
+	m.kittens[k.Id] = k
+
+	key := CollationKey(k.Name)
+	ids := m.names[key]
+	n := sort.SearchStrings(ids, k.Id)
+	ids = append(ids, "")
+	copy(ids[n+1:], ids[n:])
+	ids[n] = k.Id
+	m.names[key] = ids
+
+	if m.terms != nil {
+		m.terms.add(k.Name)
+	}
+}
+
+// remove deletes the kitten with id if it exists, the caller must hold the
+// write lock
+func (m *MemoryStore) remove(id string) {
+	k, ok := m.kittens[id]
+	if !ok {
+		return
+	}
+
+	delete(m.kittens, id)
+
+	key := CollationKey(k.Name)
+	ids := m.names[key]
+	n := sort.SearchStrings(ids, id)
+	ids = append(ids[:n], ids[n+1:]...)
+	if len(ids) == 0 {
+		delete(m.names, key)
+	} else {
+		m.names[key] = ids
+	}
+
+	// removed terms do not change the results of a search as the kittens
+	// are still matched, so the dictionary is only rebuilt once it is
+	// mostly stale
+	m.stale++
+	if m.stale > len(m.kittens) {
+		m.rebuildTerms()
+	}
+}
+
+// rebuildTerms rebuilds the dictionary used for fuzzy matching, the caller
+// must hold the write lock
+func (m *MemoryStore) rebuildTerms() {
+	names := make([]string, 0, len(m.kittens))
+	for _, k := range m.kittens {
+		names = append(names, k.Name)
+	}
+
+	m.terms = newTermDictionary(names)
+	m.stale = 0
+}
+
+// Search returns a slice of Kitten which match the query, fuzzy queries match
+// any term within the allowed edits
+func (m *MemoryStore) Search(ctx context.Context, q Query) (Result, error) {
+	if err := contextError(ctx); err != nil {
+		return Result{}, err
+	}
+
+	n, err := q.Node()
+	if err != nil {
+		return Result{}, &InvalidQueryError{Err: err}
+	}
+
+	m.once.Do(m.seed)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hits []Hit
 
-	for _, k := range data {
-		if k.Name == name {
-			kittens = append(kittens, k)
+	for _, k := range m.kittens {
+		if matches(k, n, q.Fuzziness, m.terms) {
+			hits = append(hits, Hit{Kitten: k})
 		}
 	}
 
+	return Paginate(hits, q), nil
+}
+
+// All returns every kitten in the store ordered by id
+func (m *MemoryStore) All(ctx context.Context) ([]Kitten, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	m.once.Do(m.seed)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.all(), nil
+}
+
+// all returns every kitten ordered by id, the caller must hold the lock
+func (m *MemoryStore) all() []Kitten {
+	kittens := make([]Kitten, 0, len(m.kittens))
+	for _, k := range m.kittens {
+		kittens = append(kittens, k)
+	}
+
+	sort.Slice(kittens, func(a, b int) bool { return kittens[a].Id < kittens[b].Id })
 	return kittens
 }
+
+// Get returns the kitten with id
+func (m *MemoryStore) Get(ctx context.Context, id string) (Kitten, error) {
+	if err := contextError(ctx); err != nil {
+		return Kitten{}, err
+	}
+
+	if k, ok := m.lookup(id); ok {
+		return k, nil
+	}
+
+	return Kitten{}, ErrNotFound
+}
+
+// lookup returns the kitten with id, ok is false when there is none
+func (m *MemoryStore) lookup(id string) (k Kitten, ok bool) {
+	m.once.Do(m.seed)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok = m.kittens[id]
+	return k, ok
+}
+
+// FindByName returns the kittens named name ignoring case and accents,
+// ordered by id
+func (m *MemoryStore) FindByName(name string) []Kitten {
+	m.once.Do(m.seed)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := m.names[CollationKey(name)]
+	kittens := make([]Kitten, len(ids))
+	for n, id := range ids {
+		kittens[n] = m.kittens[id]
+	}
+
+	return kittens
+}
+
+// Create adds a new kitten to the store
+func (m *MemoryStore) Create(ctx context.Context, k Kitten) (Kitten, error) {
+	if err := contextError(ctx); err != nil {
+		return Kitten{}, err
+	}
+
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.kittens[k.Id]; ok {
+		return Kitten{}, ErrExists
+	}
+
+	k.Version = 1
+	m.put(k)
+
+	return k, nil
+}
+
+// Update replaces an existing kitten when it is at the expected version
+func (m *MemoryStore) Update(ctx context.Context, k Kitten, version int64) (Kitten, error) {
+	if err := contextError(ctx); err != nil {
+		return Kitten{}, err
+	}
+
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.match(k.Id, version)
+	if err != nil {
+		return Kitten{}, err
+	}
+
+	k.Version = current.Version + 1
+	m.put(k)
+
+	return k, nil
+}
+
+// Delete removes a kitten from the store when it is at the expected version
+func (m *MemoryStore) Delete(ctx context.Context, id string, version int64) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.match(id, version); err != nil {
+		return err
+	}
+
+	m.remove(id)
+
+	return nil
+}
+
+// Import writes kittens under a single lock so searches see either none or
+// all of them
+func (m *MemoryStore) Import(ctx context.Context, kittens []Kitten, options ImportOptions) (ImportResult, error) {
+	if err := contextError(ctx); err != nil {
+		return ImportResult{}, err
+	}
+
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rows, errors := prepareImport(kittens)
+	result := ImportResult{Errors: errors}
+
+	for _, row := range rows {
+		k := kittens[row]
+		k.Version = 1
+
+		current, ok := m.kittens[k.Id]
+		switch {
+		case !ok:
+			result.Created++
+		case options.Upsert:
+			k.Version = current.Version + 1
+			result.Updated++
+		default:
+			result.Errors = append(result.Errors, RowError{Row: row, Id: k.Id, Error: ErrExists.Error()})
+			continue
+		}
+
+		m.put(k)
+		result.Kittens = append(result.Kittens, k)
+	}
+
+	sortRowErrors(result.Errors)
+
+	return result, nil
+}
+
+// Snapshot writes every kitten to w as a JSON array ordered by id
+func (m *MemoryStore) Snapshot(w io.Writer) error {
+	m.once.Do(m.seed)
+	m.mu.RLock()
+	kittens := m.all()
+	m.mu.RUnlock()
+
+	return json.NewEncoder(w).Encode(kittens)
+}
+
+// Restore replaces every kitten with those in a JSON array written by
+// Snapshot, kittens without a version are stored at version 1. The store is
+// unchanged when r can not be read.
+func (m *MemoryStore) Restore(r io.Reader) error {
+	var kittens []Kitten
+	if err := json.NewDecoder(r).Decode(&kittens); err != nil {
+		return err
+	}
+
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reset(versioned(kittens))
+
+	return nil
+}
+
+// putAll stores kittens as they are, replacing any with the same id
+func (m *MemoryStore) putAll(kittens ...Kitten) {
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range kittens {
+		m.put(k)
+	}
+}
+
+// removeId deletes the kitten with id if it exists
+func (m *MemoryStore) removeId(id string) {
+	m.once.Do(m.seed)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(id)
+}
+
+// match returns the kitten with id when it is at version, the caller must
+// hold the lock
+func (m *MemoryStore) match(id string, version int64) (Kitten, error) {
+	k, ok := m.kittens[id]
+	if !ok {
+		return Kitten{}, ErrNotFound
+	}
+
+	if version != AnyVersion && k.Version != version {
+		return Kitten{}, ErrVersionMismatch
+	}
+
+	return k, nil
+}
+
+// NewMemoryStore creates a MemoryStore holding kittens, kittens without a
+// version are stored at version 1
+func NewMemoryStore(kittens ...Kitten) *MemoryStore {
+	m := &MemoryStore{}
+	m.once.Do(func() {
+		m.reset(versioned(kittens))
+	})
+
+	return m
+}
+
+// versioned returns a copy of kittens where those without a version are at
+// version 1
+func versioned(kittens []Kitten) []Kitten {
+	out := make([]Kitten, len(kittens))
+	for n, k := range kittens {
+		if k.Version == 0 {
+			k.Version = 1
+		}
+
+		out[n] = k
+	}
+
+	return out
+}
+
+// LoadMemoryStore creates a MemoryStore holding the kittens in a JSON file
+// written by Snapshot
+func LoadMemoryStore(path string) (*MemoryStore, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := NewMemoryStore()
+	if err := m.Restore(f); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
@@ -1,6 +1,10 @@
 package data
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,14 +12,233 @@ import (
 
 func TestReturns1KittenWhenSearchGarfield(t *testing.T) {
 	store := MemoryStore{}
-	kittens := store.Search("Garfield")
+	kittens := search(t, &store, Query{Text: "Garfield"}).Hits
 
 	assert.Equal(t, 1, len(kittens))
 }
 
 func TestReturns0KittenWhenSearchTom(t *testing.T) {
 	store := MemoryStore{}
-	kittens := store.Search("Tom")
+	kittens := search(t, &store, Query{Text: "Tom"}).Hits
 
 	assert.Equal(t, 0, len(kittens))
 }
+
+func TestReturns1KittenWhenFuzzySearchGarfeild(t *testing.T) {
+	store := MemoryStore{}
+	kittens := search(t, &store, Query{Text: "garfeild", Fuzziness: FuzzinessAuto}).Hits
+
+	assert.Equal(t, 1, len(kittens))
+}
+
+func TestReturnsKittensMatchingStructuredQuery(t *testing.T) {
+	store := MemoryStore{}
+
+	assert.Equal(t, 2, len(search(t, &store, Query{Text: "felix OR garfield"}).Hits))
+	assert.Equal(t, 1, len(search(t, &store, Query{Text: `name:"fat freddy" weight:[10 TO 25]`}).Hits))
+	assert.Equal(t, 0, len(search(t, &store, Query{Text: "garfield AND weight:{* TO 35}"}).Hits))
+}
+
+func TestPaginateReturnsPagesWithCursors(t *testing.T) {
+	store := MemoryStore{}
+
+	first := search(t, &store, Query{Text: "NOT tom", Limit: 2})
+	assert.Equal(t, 3, first.Total)
+	assert.Equal(t, []string{"1", "2"}, []string{first.Hits[0].Id, first.Hits[1].Id})
+	assert.NotNil(t, first.Next)
+
+	after, err := DecodeCursor(first.Next.Encode())
+	assert.Nil(t, err)
+
+	second := search(t, &store, Query{Text: "NOT tom", Limit: 2, After: after})
+	assert.Equal(t, 1, len(second.Hits))
+	assert.Equal(t, "3", second.Hits[0].Id)
+	assert.Nil(t, second.Next)
+
+	offset := search(t, &store, Query{Text: "NOT tom", Limit: 2, Offset: 2})
+	assert.Equal(t, second.Hits, offset.Hits)
+}
+
+func TestSortsByMultipleFields(t *testing.T) {
+	store := MemoryStore{}
+
+	result := search(t, &store, Query{Text: "NOT tom", Sort: []SortField{{Field: SortWeight, Descending: true}}})
+	assert.Equal(t, "Garfield", result.Hits[0].Name)
+
+	result = search(t, &store, Query{Text: "NOT tom", Sort: []SortField{{Field: SortName}}, Limit: 1})
+	assert.Equal(t, "Fat Freddy's Cat", result.Hits[0].Name)
+
+	result = search(t, &store, Query{Text: "NOT tom", Sort: []SortField{{Field: SortName}}, Limit: 1, After: result.Next})
+	assert.Equal(t, "Felix", result.Hits[0].Name)
+}
+
+func TestCollationKeyIgnoresCaseAndAccents(t *testing.T) {
+	assert.True(t, CollationKey("émile") > CollationKey("Eddie"))
+	assert.True(t, CollationKey("émile") < CollationKey("Felix"))
+}
+
+func TestWritesAreVisibleToSearch(t *testing.T) {
+	store := MemoryStore{}
+
+	_, err := store.Create(context.Background(), Kitten{Id: "1", Name: "Felix", Weight: 1})
+	assert.Equal(t, ErrExists, err)
+	_, err = store.Create(context.Background(), Kitten{Id: "4", Name: "Tom", Weight: 15})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(search(t, &store, Query{Text: "tom"}).Hits))
+	assert.Equal(t, 1, len(search(t, &store, Query{Text: "tmo", Fuzziness: 1}).Hits))
+
+	_, err = store.Update(context.Background(), Kitten{Id: "4", Name: "Thomas", Weight: 15}, AnyVersion)
+	assert.Nil(t, err)
+	k, err := store.Get(context.Background(), "4")
+	assert.Nil(t, err)
+	assert.Equal(t, "Thomas", k.Name)
+
+	assert.Nil(t, store.Delete(context.Background(), "4", AnyVersion))
+	assert.Equal(t, ErrNotFound, store.Delete(context.Background(), "4", AnyVersion))
+	_, err = store.Update(context.Background(), Kitten{Id: "4", Name: "Tom", Weight: 1}, AnyVersion)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestWritesAreRejectedWhenTheVersionDoesNotMatch(t *testing.T) {
+	store := MemoryStore{}
+
+	k, err := store.Create(context.Background(), Kitten{Id: "4", Name: "Tom", Weight: 15})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), k.Version)
+
+	k, err = store.Update(context.Background(), Kitten{Id: "4", Name: "Thomas", Weight: 15}, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), k.Version)
+
+	_, err = store.Update(context.Background(), Kitten{Id: "4", Name: "Tommy", Weight: 15}, 1)
+	assert.Equal(t, ErrVersionMismatch, err)
+	assert.Equal(t, ErrVersionMismatch, store.Delete(context.Background(), "4", 1))
+
+	k, _ = store.Get(context.Background(), "4")
+	assert.Equal(t, "Thomas", k.Name)
+	assert.Nil(t, store.Delete(context.Background(), "4", 2))
+}
+
+func TestSearchReturnsAnErrorForInvalidQueriesAndFinishedContexts(t *testing.T) {
+	store := MemoryStore{}
+
+	_, err := store.Search(context.Background(), Query{Text: "name:"})
+	assert.IsType(t, &InvalidQueryError{}, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	_, err = store.Search(ctx, Query{Text: "felix"})
+	assert.Equal(t, ErrTimeout, err)
+}
+
+func search(t *testing.T, store Store, q Query) Result {
+	result, err := store.Search(context.Background(), q)
+	assert.Nil(t, err)
+
+	return result
+}
+
+func TestImportReportsRejectedRowsAndWritesTheRest(t *testing.T) {
+	store := MemoryStore{}
+
+	result, err := store.Import(context.Background(), []Kitten{
+		{Id: "4", Name: "Tom", Weight: 15},
+		{Id: "1", Name: "Felix", Weight: 13},
+		{Id: "5", Weight: 2},
+		{Id: "4", Name: "Thomas", Weight: 15},
+	}, ImportOptions{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Created)
+	assert.Equal(t, []int{1, 2, 3}, []int{result.Errors[0].Row, result.Errors[1].Row, result.Errors[2].Row})
+	assert.Equal(t, ErrExists.Error(), result.Errors[0].Error)
+	assert.Equal(t, 1, len(search(t, &store, Query{Text: "tom"}).Hits))
+}
+
+func TestImportReplacesExistingKittensWhenUpserting(t *testing.T) {
+	store := MemoryStore{}
+
+	result, err := store.Import(context.Background(), []Kitten{{Id: "1", Name: "Felix", Weight: 13}}, ImportOptions{Upsert: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Updated)
+	assert.Equal(t, int64(2), result.Kittens[0].Version)
+
+	k, _ := store.Get(context.Background(), "1")
+	assert.Equal(t, float32(13), k.Weight)
+}
+
+func TestNewMemoryStoreHoldsOnlyTheSeedKittens(t *testing.T) {
+	store := NewMemoryStore(Kitten{Id: "4", Name: "Tom", Weight: 15})
+
+	kittens, _ := store.All(context.Background())
+	assert.Equal(t, []Kitten{{Id: "4", Name: "Tom", Weight: 15, Version: 1}}, kittens)
+}
+
+func TestFindByNameIgnoresCaseAndAccentsAndFollowsWrites(t *testing.T) {
+	store := NewMemoryStore(Kitten{Id: "4", Name: "Émile", Weight: 3}, Kitten{Id: "5", Name: "emile", Weight: 4})
+
+	assert.Equal(t, 2, len(store.FindByName("EMILE")))
+
+	store.Update(context.Background(), Kitten{Id: "4", Name: "Tom", Weight: 3}, AnyVersion)
+	assert.Equal(t, []Kitten{{Id: "5", Name: "emile", Weight: 4, Version: 1}}, store.FindByName("emile"))
+	assert.Equal(t, 1, len(store.FindByName("tom")))
+}
+
+func TestSnapshotRestoresIntoAnotherStore(t *testing.T) {
+	store := NewMemoryStore(Kitten{Id: "4", Name: "Tom", Weight: 15})
+	store.Update(context.Background(), Kitten{Id: "4", Name: "Thomas", Weight: 15}, AnyVersion)
+
+	b := &bytes.Buffer{}
+	assert.Nil(t, store.Snapshot(b))
+
+	restored := MemoryStore{}
+	assert.Nil(t, restored.Restore(b))
+
+	k, err := restored.Get(context.Background(), "4")
+	assert.Nil(t, err)
+	assert.Equal(t, Kitten{Id: "4", Name: "Thomas", Weight: 15, Version: 2}, k)
+
+	_, err = restored.Get(context.Background(), "1")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestRestoreStoresKittensWithoutAVersionAtVersion1(t *testing.T) {
+	store := MemoryStore{}
+	assert.Nil(t, store.Restore(bytes.NewBufferString(`[{"id":"4","name":"Tom","weight":15}]`)))
+
+	k, err := store.Get(context.Background(), "4")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), k.Version)
+
+	_, err = store.Update(context.Background(), Kitten{Id: "4", Name: "Thomas", Weight: 15}, 1)
+	assert.Nil(t, err)
+}
+
+func TestConcurrentReadsAndWrites(t *testing.T) {
+	store := NewMemoryStore()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for n := 0; n < 50; n++ {
+				id := fmt.Sprintf("%d-%d", w, n)
+				store.Create(context.Background(), Kitten{Id: id, Name: "Tom", Weight: 1})
+				store.Search(context.Background(), Query{Text: "tom", Fuzziness: FuzzinessAuto})
+				store.FindByName("tom")
+				store.Update(context.Background(), Kitten{Id: id, Name: "Tom Cat", Weight: 2}, 1)
+				if n%2 == 0 {
+					store.Delete(context.Background(), id, AnyVersion)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, len(store.FindByName("tom cat")))
+}
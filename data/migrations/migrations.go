@@ -0,0 +1,209 @@
+// Package migrations evolves the MySQL schema used by the search service.
+//
+// Migrations are compiled into the binary and applied in order, the version
+// of every applied migration is recorded in the schema_migrations table. A
+// named MySQL lock ensures only one instance migrates a database at a time.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// lockName is the MySQL named lock held while migrating
+const lockName = "kittens.schema_migrations"
+
+// DefaultLockTimeout is how long a Migrator waits for another instance to
+// finish migrating
+const DefaultLockTimeout = 60 * time.Second
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	Version int NOT NULL PRIMARY KEY,
+	Name varchar(200) NOT NULL,
+	AppliedAt datetime NOT NULL
+)`
+
+// Migration changes the schema from the previous version to Version, Down
+// reverses the change
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Migrator applies and reverts migrations on a database
+type Migrator struct {
+	db          *sql.DB
+	migrations  []Migration
+	LockTimeout time.Duration
+}
+
+// Latest returns the version of the schema once every migration is applied
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the last migration applied to the database,
+// the tracking table is created when it does not exist
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if _, err := m.db.ExecContext(ctx, createTable); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := m.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(Version), 0) FROM schema_migrations").Scan(&version)
+
+	return version, err
+}
+
+// Up applies every migration which has not been applied and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.migrate(ctx, func(current int) ([]Migration, error) {
+		return pending(m.migrations, current), nil
+	}, m.up)
+}
+
+// Down reverts every applied migration newer than target, newest first, and
+// returns them
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	return m.migrate(ctx, func(current int) ([]Migration, error) {
+		return rollback(m.migrations, current, target)
+	}, m.down)
+}
+
+// migrate holds the lock while plan chooses the migrations to run against the
+// current version and apply runs each of them
+func (m *Migrator) migrate(
+	ctx context.Context,
+	plan func(current int) ([]Migration, error),
+	apply func(ctx context.Context, migration Migration) error,
+) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	current, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := plan(current)
+	if err != nil {
+		return nil, err
+	}
+
+	for n, migration := range migrations {
+		if err := apply(ctx, migration); err != nil {
+			return migrations[:n], fmt.Errorf("migration %d %s failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	return migrations, nil
+}
+
+func (m *Migrator) up(ctx context.Context, migration Migration) error {
+	log.Printf("Applying migration %d: %s", migration.Version, migration.Name)
+
+	for _, statement := range migration.Up {
+		if _, err := m.db.ExecContext(ctx, statement); err != nil { //nolint:safesql
+			return err
+		}
+	}
+
+	_, err := m.db.ExecContext(ctx, "INSERT INTO schema_migrations (Version, Name, AppliedAt) VALUES (?, ?, ?)",
+		migration.Version, migration.Name, time.Now().UTC())
+
+	return err
+}
+
+func (m *Migrator) down(ctx context.Context, migration Migration) error {
+	log.Printf("Reverting migration %d: %s", migration.Version, migration.Name)
+
+	for _, statement := range migration.Down {
+		if _, err := m.db.ExecContext(ctx, statement); err != nil { //nolint:safesql
+			return err
+		}
+	}
+
+	_, err := m.db.ExecContext(ctx, "DELETE FROM schema_migrations WHERE Version=?", migration.Version)
+
+	return err
+}
+
+// lock acquires the named migration lock and returns a function which
+// releases it. Named locks belong to a connection, and survive the rollback
+// of a transaction, so the lock is taken and released on a single connection
+// held out of the pool until it is released.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lockName, int(m.LockTimeout/time.Second)).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if acquired.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("timed out waiting for another instance to finish migrating")
+	}
+
+	return func() {
+		// the lock is released even when ctx has been cancelled, otherwise
+		// it is held by the pooled connection until that is closed
+		if _, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName); err != nil {
+			log.Println("Unable to release the migrations lock:", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// pending returns the migrations newer than current
+func pending(migrations []Migration, current int) []Migration {
+	for n, migration := range migrations {
+		if migration.Version > current {
+			return migrations[n:]
+		}
+	}
+
+	return nil
+}
+
+// rollback returns the migrations to revert to move from current to target,
+// newest first
+func rollback(migrations []Migration, current, target int) ([]Migration, error) {
+	if target < 0 || target > current {
+		return nil, fmt.Errorf("can not migrate down from version %d to %d", current, target)
+	}
+
+	var out []Migration
+	for n := len(migrations) - 1; n >= 0; n-- {
+		if migrations[n].Version > target && migrations[n].Version <= current {
+			out = append(out, migrations[n])
+		}
+	}
+
+	return out, nil
+}
+
+func NewMigrator(db *sql.DB) *Migrator {
+	return &Migrator{
+		db:          db,
+		migrations:  schema,
+		LockTimeout: DefaultLockTimeout,
+	}
+}
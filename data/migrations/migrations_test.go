@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaVersionsIncreaseAndCanBeReverted(t *testing.T) {
+	for n, migration := range schema {
+		assert.Equal(t, n+1, migration.Version)
+		assert.NotEmpty(t, migration.Up, migration.Name)
+		assert.NotEmpty(t, migration.Down, migration.Name)
+	}
+}
+
+func TestPendingReturnsMigrationsNewerThanCurrent(t *testing.T) {
+	assert.Equal(t, schema, pending(schema, 0))
+	assert.Equal(t, schema[1:], pending(schema, 1))
+	assert.Empty(t, pending(schema, len(schema)))
+}
+
+func TestRollbackReturnsAppliedMigrationsNewestFirst(t *testing.T) {
+	migrations, err := rollback(schema, 3, 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Migration{schema[2], schema[1]}, migrations)
+}
+
+func TestRollbackRejectsTargetsAboveTheCurrentVersion(t *testing.T) {
+	_, err := rollback(schema, 1, 2)
+
+	assert.NotNil(t, err)
+}
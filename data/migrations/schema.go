@@ -0,0 +1,49 @@
+package migrations
+
+// schema is every migration in the order it is applied, a migration must
+// never be changed once it has been released, add a new one instead
+var schema = []Migration{
+	{
+		Version: 1,
+		Name:    "create kittens",
+		// existing databases created by terraform already have this table
+		Up: []string{
+			"CREATE TABLE IF NOT EXISTS Kittens (Id varchar(50), Name varchar(200), Weight int)",
+		},
+		Down: []string{
+			"DROP TABLE Kittens",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add kitten version",
+		Up: []string{
+			"ALTER TABLE Kittens ADD COLUMN Version bigint NOT NULL DEFAULT 1",
+		},
+		Down: []string{
+			"ALTER TABLE Kittens DROP COLUMN Version",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "store weight as float and key kittens by id",
+		Up: []string{
+			"ALTER TABLE Kittens MODIFY Id varchar(50) NOT NULL, MODIFY Weight float NOT NULL, ADD PRIMARY KEY (Id)",
+		},
+		Down: []string{
+			"ALTER TABLE Kittens DROP PRIMARY KEY, MODIFY Weight int, MODIFY Id varchar(50)",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "store kittens as utf8mb4 and sort names with the unicode collation",
+		// names are sorted like the index only with this collation, and the
+		// default latin1 of older servers can not store every name
+		Up: []string{
+			"ALTER TABLE Kittens CONVERT TO CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci",
+		},
+		Down: []string{
+			"ALTER TABLE Kittens CONVERT TO CHARACTER SET latin1 COLLATE latin1_swedish_ci",
+		},
+	},
+}
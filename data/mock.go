@@ -1,15 +1,61 @@
 package data
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
 
 // MockStore is a mock implementation of a datastore for testing purposes
 type MockStore struct {
 	mock.Mock
 }
 
-//Search returns the object which was passed to the mock on setup
-func (m *MockStore) Search(name string) []Kitten {
-	args := m.Mock.Called(name)
+//Search returns the objects which was passed to the mock on setup
+func (m *MockStore) Search(ctx context.Context, q Query) (Result, error) {
+	args := m.Mock.Called(q)
+
+	return args.Get(0).(Result), args.Error(1)
+}
+
+// All returns the objects which were passed to the mock on setup
+func (m *MockStore) All(ctx context.Context) ([]Kitten, error) {
+	args := m.Mock.Called()
+
+	return args.Get(0).([]Kitten), args.Error(1)
+}
+
+// Get returns the objects which were passed to the mock on setup
+func (m *MockStore) Get(ctx context.Context, id string) (Kitten, error) {
+	args := m.Mock.Called(id)
+
+	return args.Get(0).(Kitten), args.Error(1)
+}
+
+// Create returns the objects which were passed to the mock on setup
+func (m *MockStore) Create(ctx context.Context, k Kitten) (Kitten, error) {
+	args := m.Mock.Called(k)
+
+	return args.Get(0).(Kitten), args.Error(1)
+}
+
+// Update returns the objects which were passed to the mock on setup
+func (m *MockStore) Update(ctx context.Context, k Kitten, version int64) (Kitten, error) {
+	args := m.Mock.Called(k, version)
+
+	return args.Get(0).(Kitten), args.Error(1)
+}
+
+// Delete returns the error which was passed to the mock on setup
+func (m *MockStore) Delete(ctx context.Context, id string, version int64) error {
+	args := m.Mock.Called(id, version)
+
+	return args.Error(0)
+}
+
+// Import returns the objects which were passed to the mock on setup
+func (m *MockStore) Import(ctx context.Context, kittens []Kitten, options ImportOptions) (ImportResult, error) {
+	args := m.Mock.Called(kittens, options)
 
-	return args.Get(0).([]Kitten)
+	return args.Get(0).(ImportResult), args.Error(1)
 }
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data/analysis"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
+)
+
+// fragment is a piece of SQL text, only untyped string constants convert to
+// a fragment implicitly which ensures values from a query can only reach the
+// database as parameters
+type fragment string
+
+// sqlBuilder assembles a parameterized SQL statement from constant fragments
+type sqlBuilder struct {
+	text bytes.Buffer
+	args []interface{}
+}
+
+func (b *sqlBuilder) write(f fragment) {
+	b.text.WriteString(string(f))
+}
+
+// bind writes f which must contain a placeholder for each of args
+func (b *sqlBuilder) bind(f fragment, args ...interface{}) {
+	b.write(f)
+	b.args = append(b.args, args...)
+}
+
+// append writes the text and arguments of other
+func (b *sqlBuilder) append(other *sqlBuilder) {
+	b.text.Write(other.text.Bytes())
+	b.args = append(b.args, other.args...)
+}
+
+func (b *sqlBuilder) String() string {
+	return b.text.String()
+}
+
+// compileWhere writes the WHERE clause for the query syntax tree n, terms
+// are matched as whole words of the name and fuzzy terms are expanded to the
+// terms in dictionary within the allowed edits
+func compileWhere(b *sqlBuilder, n query.Node, fuzziness Fuzziness, dictionary *termDictionary) {
+	switch n := n.(type) {
+	case query.And:
+		compileList(b, n.Children, " AND ", fuzziness, dictionary)
+	case query.Or:
+		compileList(b, n.Children, " OR ", fuzziness, dictionary)
+	case query.Not:
+		b.write("NOT ")
+		compileWhere(b, n.Child, fuzziness, dictionary)
+	case query.Term:
+		// a term such as "abc-123" is split into several terms which are
+		// matched as a phrase, like the index only single terms are fuzzy
+		candidates := []string{n.Value}
+		if terms := analysis.Tokenize(n.Value); len(terms) == 1 {
+			if distance := fuzziness.Distance(terms[0]); distance > 0 {
+				candidates = dictionary.lookup(terms[0], distance)
+			}
+		}
+
+		compileText(b, n.Field, candidates)
+	case query.Phrase:
+		compileText(b, n.Field, []string{n.Value})
+	case query.Range:
+		compileRange(b, n)
+	default:
+		b.write("FALSE")
+	}
+}
+
+func compileList(b *sqlBuilder, children []query.Node, operator fragment, fuzziness Fuzziness, dictionary *termDictionary) {
+	b.write("(")
+	for i, child := range children {
+		if i > 0 {
+			b.write(operator)
+		}
+
+		compileWhere(b, child, fuzziness, dictionary)
+	}
+	b.write(")")
+}
+
+// compileText matches any of values as whole terms of the named text
+// field, ids are analyzed into terms in the same way as names
+func compileText(b *sqlBuilder, field string, values []string) {
+	pattern := termPattern(values)
+	if pattern == "" {
+		b.write("FALSE")
+		return
+	}
+
+	b.write("(")
+	switch field {
+	case query.FieldId:
+		b.bind("Id REGEXP ?", pattern)
+	case query.FieldName:
+		b.bind("Name REGEXP ?", pattern)
+	default:
+		b.bind("Name REGEXP ? OR Id REGEXP ?", pattern, pattern)
+	}
+	b.write(")")
+}
+
+func compileRange(b *sqlBuilder, r query.Range) {
+	b.write("(TRUE")
+
+	if r.Min != nil {
+		if r.IncludeMin {
+			b.bind(" AND Weight >= ?", *r.Min)
+		} else {
+			b.bind(" AND Weight > ?", *r.Min)
+		}
+	}
+
+	if r.Max != nil {
+		if r.IncludeMax {
+			b.bind(" AND Weight <= ?", *r.Max)
+		} else {
+			b.bind(" AND Weight < ?", *r.Max)
+		}
+	}
+
+	b.write(")")
+}
+
+// termSeparator matches the characters between the terms of a field, and
+// termPossessive the "'s" which the analyzer removes from a term
+const (
+	termSeparator  = "[^[:alnum:]']"
+	termPossessive = "('s)?"
+)
+
+// termPattern returns a regular expression matching any of values as whole
+// terms of a field, so that MySQL finds the same kittens as the index which
+// matches analyzed terms exactly. Terms only hold letters and digits so they
+// need no escaping. Unlike the analyzer the pattern does not join words split
+// by an apostrophe, "o'malley" is only found by the terms o and malley.
+func termPattern(values []string) string {
+	var alternatives []string
+	for _, value := range values {
+		terms := analysis.Tokenize(value)
+		if len(terms) == 0 {
+			continue
+		}
+
+		alternatives = append(alternatives, strings.Join(terms, termPossessive+termSeparator+"+"))
+	}
+
+	if len(alternatives) == 0 {
+		return ""
+	}
+
+	sort.Strings(alternatives)
+	return "(^|" + termSeparator + ")(" + strings.Join(alternatives, "|") + ")" + termPossessive + "(" + termSeparator + "|$)"
+}
+
+// sortColumns maps the fields results can be sorted by to their columns,
+// MySQL does not score results so relevance is not included
+var sortColumns = map[string]fragment{
+	SortName:   "Name",
+	SortWeight: "Weight",
+	SortId:     "Id",
+}
+
+// termColumns maps the fields which support terms aggregations to their
+// columns
+var termColumns = map[string]fragment{
+	SortName: "Name",
+	SortId:   "Id",
+}
+
+// mysqlSort returns the sort fields supported by MySQL ending with Id so
+// that the order is total and can be used for keyset pagination
+func mysqlSort(fields []SortField) []SortField {
+	var out []SortField
+	for _, f := range fields {
+		if _, ok := sortColumns[f.Field]; !ok {
+			continue
+		}
+
+		out = append(out, f)
+		if f.Field == SortId {
+			return out
+		}
+	}
+
+	return append(out, SortField{Field: SortId})
+}
+
+// compileOrderBy writes the ORDER BY clause for fields
+func compileOrderBy(b *sqlBuilder, fields []SortField) {
+	b.write(" ORDER BY ")
+	for i, f := range fields {
+		if i > 0 {
+			b.write(", ")
+		}
+
+		b.write(sortColumns[f.Field])
+		if f.Descending {
+			b.write(" DESC")
+		} else {
+			b.write(" ASC")
+		}
+	}
+}
+
+// compileKeyset writes a condition selecting the rows which follow the
+// cursor when ordered by fields
+func compileKeyset(b *sqlBuilder, fields []SortField, c *Cursor) {
+	b.write(" AND (")
+	for i, f := range fields {
+		if i > 0 {
+			b.write(" OR ")
+		}
+
+		b.write("(")
+		for _, equal := range fields[:i] {
+			b.write(sortColumns[equal.Field])
+			b.bind(" = ? AND ", cursorValue(equal.Field, c))
+		}
+
+		b.write(sortColumns[f.Field])
+		if f.Descending {
+			b.bind(" < ?", cursorValue(f.Field, c))
+		} else {
+			b.bind(" > ?", cursorValue(f.Field, c))
+		}
+		b.write(")")
+	}
+	b.write(")")
+}
+
+func cursorValue(field string, c *Cursor) interface{} {
+	switch field {
+	case SortName:
+		return c.Name
+	case SortWeight:
+		return c.Weight
+	}
+
+	return c.Id
+}
+
+// compileInsert writes a multi-row INSERT of kittens at version 1, when
+// upserting existing kittens are replaced and their version incremented
+func compileInsert(b *sqlBuilder, kittens []Kitten, upsert bool) {
+	b.write("INSERT INTO Kittens (Id, Name, Weight, Version) VALUES ")
+	for n, k := range kittens {
+		if n > 0 {
+			b.write(", ")
+		}
+
+		b.bind("(?, ?, ?, 1)", k.Id, k.Name, k.Weight)
+	}
+
+	if upsert {
+		b.write(" ON DUPLICATE KEY UPDATE Name = VALUES(Name), Weight = VALUES(Weight), Version = Version + 1")
+	}
+}
+
+// compileIds writes a parenthesized list of the ids of kittens
+func compileIds(b *sqlBuilder, kittens []Kitten) {
+	b.write("(")
+	for n, k := range kittens {
+		if n > 0 {
+			b.write(", ")
+		}
+
+		b.bind("?", k.Id)
+	}
+	b.write(")")
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileWhereParameterizesEveryValue(t *testing.T) {
+	b := &sqlBuilder{}
+	compileWhere(b, query.MustParse(`name:felix OR NOT id:"1" weight:[10 TO 25}`), FuzzinessNone, nil)
+
+	assert.Equal(t, "((Name REGEXP ?) OR (NOT (Id REGEXP ?) AND (TRUE AND Weight >= ? AND Weight < ?)))", b.String())
+	assert.Equal(t, []interface{}{termPattern([]string{"felix"}), termPattern([]string{"1"}), 10.0, 25.0}, b.args)
+}
+
+func TestCompileWhereExpandsFuzzyTerms(t *testing.T) {
+	b := &sqlBuilder{}
+	compileWhere(b, query.MustParse("name:garfeild"), FuzzinessAuto, newTermDictionary([]string{"Garfield", "Felix"}))
+
+	assert.Equal(t, "(Name REGEXP ?)", b.String())
+	assert.Equal(t, []interface{}{termPattern([]string{"garfield"})}, b.args)
+}
+
+func TestCompileWhereMatchesEveryFuzzyCandidateInOneStatement(t *testing.T) {
+	b := &sqlBuilder{}
+	compileWhere(b, query.MustParse("garfeild"), FuzzinessAuto, newTermDictionary([]string{"Garfield", "Garfeld", "Felix"}))
+
+	pattern := termPattern([]string{"garfield", "garfeld"})
+	assert.Equal(t, "(Name REGEXP ? OR Id REGEXP ?)", b.String())
+	assert.Equal(t, []interface{}{pattern, pattern}, b.args)
+}
+
+func TestCompileWhereTokenizesFuzzyTerms(t *testing.T) {
+	b := &sqlBuilder{}
+	compileWhere(b, query.MustParse("name:Garfeild"), FuzzinessAuto, newTermDictionary([]string{"Garfield"}))
+	assert.Equal(t, []interface{}{termPattern([]string{"garfield"})}, b.args)
+
+	b = &sqlBuilder{}
+	compileWhere(b, query.MustParse("name:fat-cat"), FuzzinessAuto, newTermDictionary([]string{"Fat Cat"}))
+	assert.Equal(t, []interface{}{termPattern([]string{"fat-cat"})}, b.args)
+}
+
+func TestCompileWhereMatchesIdsAsTerms(t *testing.T) {
+	b := &sqlBuilder{}
+	compileWhere(b, query.MustParse("id:ABC"), FuzzinessNone, nil)
+
+	assert.Equal(t, "(Id REGEXP ?)", b.String())
+	assert.Regexp(t, "(?i)"+b.args[0].(string), "abc-123")
+	assert.NotRegexp(t, "(?i)"+b.args[0].(string), "abcd-123")
+}
+
+func TestTermPatternMatchesWholeTerms(t *testing.T) {
+	matches := func(value, name string) bool {
+		return regexp.MustCompile("(?i)" + termPattern([]string{value})).MatchString(name)
+	}
+
+	assert.True(t, matches("cat", "Fat Cat"))
+	assert.True(t, matches("cat", "Cat"))
+	assert.True(t, matches("freddy", "Freddy's Cat"))
+	assert.True(t, matches("fat cat", "Fat-Cat"))
+	assert.False(t, matches("cat", "Catherine"))
+	assert.False(t, matches("cat", "Bobcat"))
+	assert.False(t, matches("fat cat", "Cat Fat"))
+	assert.Equal(t, "", termPattern([]string{"%_"}))
+}
+
+func TestCompileKeysetFollowsEverySortField(t *testing.T) {
+	fields := mysqlSort([]SortField{{Field: SortRelevance, Descending: true}, {Field: SortWeight, Descending: true}})
+
+	b := &sqlBuilder{}
+	compileKeyset(b, fields, &Cursor{Weight: 20, Id: "2"})
+	compileOrderBy(b, fields)
+
+	assert.Equal(t, " AND ((Weight < ?) OR (Weight = ? AND Id > ?)) ORDER BY Weight DESC, Id ASC", b.String())
+	assert.Equal(t, []interface{}{float32(20), float32(20), "2"}, b.args)
+}
+
+func TestCompileInsertWritesEveryKittenAndUpserts(t *testing.T) {
+	b := &sqlBuilder{}
+	compileInsert(b, []Kitten{{Id: "1", Name: "Felix", Weight: 12}, {Id: "2", Name: "Tom", Weight: 15}}, true)
+
+	assert.Equal(t, "INSERT INTO Kittens (Id, Name, Weight, Version) VALUES (?, ?, ?, 1), (?, ?, ?, 1)"+
+		" ON DUPLICATE KEY UPDATE Name = VALUES(Name), Weight = VALUES(Weight), Version = Version + 1", b.String())
+	assert.Equal(t, []interface{}{"1", "Felix", float32(12), "2", "Tom", float32(15)}, b.args)
+}
@@ -1,15 +1,45 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
 	"log"
+	"net"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/migrations"
+	"github.com/go-sql-driver/mysql"
 )
 
+// errDuplicateKey is the MySQL error number for a duplicate primary key
+const errDuplicateKey = 1062
+
+// transientErrors are the MySQL error numbers of failures which may succeed
+// when retried, too many connections, lock wait timeout and deadlock
+var transientErrors = map[uint16]bool{
+	1040: true,
+	1205: true,
+	1213: true,
+}
+
+// maxUpdateAttempts is how many times an update made regardless of version
+// is retried when another writer changes the kitten at the same time
+const maxUpdateAttempts = 3
+
+// termsTTL is how long the dictionary of terms used for fuzzy searches is
+// cached before it is reloaded
+const termsTTL = 30 * time.Second
+
 // MySQLStore is a MongoDB data store which implements the Store interface
 type MySQLStore struct {
 	session *sql.DB
+
+	termsMutex  sync.Mutex
+	terms       *termDictionary
+	termsLoaded time.Time
 }
 
 // NewMySQLStore creates an instance of MySQLStore with the given connection string
@@ -31,54 +61,588 @@ func NewMySQLStore(connection string) (*MySQLStore, error) {
 	return &MySQLStore{session: db}, nil
 }
 
-// Search returns Kittens from the MySQL instance which have the name name
-func (m *MySQLStore) Search(name string) []Kitten {
-	log.Println("Search for:", name)
+// Search returns a page of Kittens from the MySQL instance which match the
+// query, the query is compiled to a parameterized WHERE clause and fuzzy
+// terms are first expanded to the stored terms within the allowed edits.
+// Results are ordered by the query's sort fields, with relevance ignored as
+// MySQL does not score results, and cursors use keyset pagination so deep
+// pages do not scan the preceding rows.
+func (m *MySQLStore) Search(ctx context.Context, q Query) (Result, error) {
+	log.Println("Search for:", q.Text)
+
+	n, err := q.Node()
+	if err != nil {
+		return Result{}, &InvalidQueryError{Err: err}
+	}
+
+	var dictionary *termDictionary
+	if q.Fuzziness != FuzzinessNone {
+		dictionary, err = m.termDictionary(ctx)
+		if err != nil {
+			return Result{}, storeError(ctx, err)
+		}
+	}
+
+	where := &sqlBuilder{}
+	compileWhere(where, n, q.Fuzziness, dictionary)
+
+	result := Result{}
+
+	// the statements are assembled only from constant fragments, every value
+	// from the query is passed as a parameter
+	count := &sqlBuilder{}
+	count.write("SELECT COUNT(*) FROM Kittens WHERE ")
+	count.append(where)
+
+	err = m.session.QueryRowContext(ctx, count.String(), count.args...).Scan(&result.Total) //nolint:safesql
+	if err != nil {
+		return Result{}, storeError(ctx, err)
+	}
+
+	result.Aggregations, err = m.aggregate(ctx, where, q.Aggregations)
+	if err != nil {
+		return Result{}, storeError(ctx, err)
+	}
+
+	fields := mysqlSort(q.sortFields())
+
+	page := &sqlBuilder{}
+	page.write("SELECT Id, Name, Weight, Version FROM Kittens WHERE (")
+	page.append(where)
+	page.write(")")
+	if q.After != nil {
+		compileKeyset(page, fields, q.After)
+	}
+
+	compileOrderBy(page, fields)
+
+	// one extra row is read to find whether there is a following page
+	page.bind(" LIMIT ?", q.limit()+1)
+	if q.Offset > 0 {
+		page.bind(" OFFSET ?", q.Offset)
+	}
+
+	rows, err := m.session.QueryContext(ctx, page.String(), page.args...) //nolint:safesql
+	if err != nil {
+		return Result{}, storeError(ctx, err)
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		kitten := Kitten{}
+		if err := rows.Scan(&kitten.Id, &kitten.Name, &kitten.Weight, &kitten.Version); err != nil {
+			return Result{}, storeError(ctx, err)
+		}
+
+		result.Hits = append(result.Hits, Hit{Kitten: kitten})
+	}
+
+	if err := rows.Err(); err != nil {
+		return Result{}, storeError(ctx, err)
+	}
+
+	if len(result.Hits) > q.limit() {
+		result.Hits = result.Hits[:q.limit()]
+		result.Next = CursorFor(result.Hits[len(result.Hits)-1])
+	}
+
+	return result, nil
+}
+
+// aggregate computes each aggregation over the rows matching where using
+// GROUP BY queries
+func (m *MySQLStore) aggregate(ctx context.Context, where *sqlBuilder, aggregations []Aggregation) (map[string]AggregationResult, error) {
+	if len(aggregations) == 0 {
+		return nil, nil
+	}
+
+	results := make(map[string]AggregationResult)
+	for _, a := range aggregations {
+		b := &sqlBuilder{}
+
+		switch a.Type {
+		case AggregationHistogram:
+			b.bind("SELECT FLOOR(Weight / ?) * ? AS Bucket, COUNT(*) FROM Kittens WHERE ", a.Interval, a.Interval)
+			b.append(where)
+			// one more bucket than is returned detects a truncated histogram
+			b.bind(" GROUP BY Bucket ORDER BY Bucket LIMIT ?", MaxHistogramBuckets+1)
+		case AggregationTerms:
+			column := termColumns[a.Field]
+
+			b.write("SELECT ")
+			b.write(column)
+			b.write(", COUNT(*) AS Count FROM Kittens WHERE ")
+			b.append(where)
+			b.write(" GROUP BY ")
+			b.write(column)
+			b.write(" ORDER BY Count DESC, ")
+			b.write(column)
+			b.bind(" LIMIT ?", a.size())
+		case AggregationStats:
+			b.write("SELECT COUNT(Weight), COALESCE(MIN(Weight), 0), COALESCE(MAX(Weight), 0), COALESCE(AVG(Weight), 0), COALESCE(SUM(Weight), 0) FROM Kittens WHERE ")
+			b.append(where)
+
+			s := &Stats{}
+			err := m.session.QueryRowContext(ctx, b.String(), b.args...).Scan(&s.Count, &s.Min, &s.Max, &s.Avg, &s.Sum) //nolint:safesql
+			if err != nil {
+				return nil, err
+			}
+
+			results[a.Name] = AggregationResult{Stats: s}
+			continue
+		}
+
+		rows, err := m.session.QueryContext(ctx, b.String(), b.args...) //nolint:safesql
+		if err != nil {
+			return nil, err
+		}
+
+		result := AggregationResult{Buckets: []Bucket{}}
+		for rows.Next() {
+			bucket := Bucket{}
+			if a.Type == AggregationHistogram {
+				var key float64
+				err = rows.Scan(&key, &bucket.Count)
+				bucket.Key = key
+			} else {
+				var key string
+				err = rows.Scan(&key, &bucket.Count)
+				bucket.Key = key
+			}
+
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			if a.Type == AggregationHistogram && len(result.Buckets) == MaxHistogramBuckets {
+				result.Truncated = true
+				continue
+			}
+
+			result.Buckets = append(result.Buckets, bucket)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		results[a.Name] = result
+	}
+
+	return results, nil
+}
+
+// termDictionary returns the cached dictionary of terms in kitten names,
+// reloading it from the database when it has expired
+func (m *MySQLStore) termDictionary(ctx context.Context) (*termDictionary, error) {
+	m.termsMutex.Lock()
+	defer m.termsMutex.Unlock()
+
+	if m.terms != nil && time.Since(m.termsLoaded) < termsTTL {
+		return m.terms, nil
+	}
+
+	var names []string
+
+	rows, err := m.session.QueryContext(ctx, "SELECT DISTINCT Name FROM Kittens")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	m.terms = newTermDictionary(names)
+	m.termsLoaded = time.Now()
+
+	return m.terms, nil
+}
+
+// invalidateTerms discards the cached term dictionary after a write
+func (m *MySQLStore) invalidateTerms() {
+	m.termsMutex.Lock()
+	defer m.termsMutex.Unlock()
+
+	m.terms = nil
+}
+
+// All returns every Kitten in the MySQL instance
+func (m *MySQLStore) All(ctx context.Context) ([]Kitten, error) {
 	var results []Kitten
 
-	rows, err := m.session.Query("SELECT Id, Name, Weight FROM Kittens WHERE Name=?", name)
+	rows, err := m.session.QueryContext(ctx, "SELECT Id, Name, Weight, Version FROM Kittens")
 	if err != nil {
-		return nil
+		return nil, storeError(ctx, err)
 	}
 
 	defer rows.Close()
 	for rows.Next() {
 		kitten := Kitten{}
-		rows.Scan(&kitten.Id, &kitten.Name, &kitten.Weight)
+		if err := rows.Scan(&kitten.Id, &kitten.Name, &kitten.Weight, &kitten.Version); err != nil {
+			return nil, storeError(ctx, err)
+		}
+
 		results = append(results, kitten)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil
+		return nil, storeError(ctx, err)
+	}
+
+	return results, nil
+}
+
+// storeError logs err and converts it to one of the errors returned by Store,
+// errors reported by the server are returned unchanged while failures to
+// reach it, and server errors which are transient such as a deadlock, are
+// ErrUnavailable
+func storeError(ctx context.Context, err error) error {
+	log.Println(err)
+
+	if ctxErr := contextError(ctx); ctxErr != nil {
+		return ctxErr
+	}
+
+	if e, ok := err.(*mysql.MySQLError); ok && !transientErrors[e.Number] {
+		return err
+	}
+
+	return ErrUnavailable
+}
+
+// IsTransient returns true when err is a failure to reach the store, or an
+// error reported by MySQL, which may not happen again when the call is
+// retried
+func IsTransient(err error) bool {
+	switch err {
+	case ErrUnavailable, ErrTimeout, driver.ErrBadConn, mysql.ErrInvalidConn:
+		return true
+	}
+
+	switch e := err.(type) {
+	case *mysql.MySQLError:
+		return transientErrors[e.Number]
+	case net.Error:
+		return true
+	}
+
+	return false
+}
+
+// Get returns the Kitten with id from the MySQL instance
+func (m *MySQLStore) Get(ctx context.Context, id string) (Kitten, error) {
+	kitten := Kitten{}
+
+	err := m.session.QueryRowContext(ctx, "SELECT Id, Name, Weight, Version FROM Kittens WHERE Id=?", id).
+		Scan(&kitten.Id, &kitten.Name, &kitten.Weight, &kitten.Version)
+	if err == sql.ErrNoRows {
+		return Kitten{}, ErrNotFound
+	}
+
+	if err != nil {
+		return Kitten{}, storeError(ctx, err)
 	}
 
-	return results
+	return kitten, nil
+}
+
+// Create inserts a new Kitten into the MySQL instance
+func (m *MySQLStore) Create(ctx context.Context, k Kitten) (Kitten, error) {
+	defer m.invalidateTerms()
+
+	k.Version = 1
+	_, err := m.session.ExecContext(ctx, "INSERT INTO Kittens (Id, Name, Weight, Version) VALUES (?, ?, ?, ?)", k.Id, k.Name, k.Weight, k.Version)
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == errDuplicateKey {
+		return Kitten{}, ErrExists
+	}
+
+	if err != nil {
+		return Kitten{}, storeError(ctx, err)
+	}
+
+	return k, nil
+}
+
+// Update replaces an existing Kitten in the MySQL instance, the version is
+// checked by the UPDATE statement so a concurrent write can not be lost
+func (m *MySQLStore) Update(ctx context.Context, k Kitten, version int64) (Kitten, error) {
+	defer m.invalidateTerms()
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		expected := version
+		if version == AnyVersion {
+			current, err := m.Get(ctx, k.Id)
+			if err != nil {
+				return Kitten{}, err
+			}
+
+			expected = current.Version
+		}
+
+		result, err := m.session.ExecContext(ctx, "UPDATE Kittens SET Name=?, Weight=?, Version=Version+1 WHERE Id=? AND Version=?",
+			k.Name, k.Weight, k.Id, expected)
+		if err != nil {
+			return Kitten{}, storeError(ctx, err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return Kitten{}, storeError(ctx, err)
+		}
+
+		if n > 0 {
+			k.Version = expected + 1
+			return k, nil
+		}
+
+		// an unconditional update which lost a race with another writer is
+		// retried against the new version
+		if err := m.conflict(ctx, k.Id); err != ErrVersionMismatch || version != AnyVersion {
+			return Kitten{}, err
+		}
+	}
+
+	return Kitten{}, ErrVersionMismatch
+}
+
+// Delete removes a Kitten from the MySQL instance
+func (m *MySQLStore) Delete(ctx context.Context, id string, version int64) error {
+	defer m.invalidateTerms()
+
+	var result sql.Result
+	var err error
+	if version == AnyVersion {
+		result, err = m.session.ExecContext(ctx, "DELETE FROM Kittens WHERE Id=?", id)
+	} else {
+		result, err = m.session.ExecContext(ctx, "DELETE FROM Kittens WHERE Id=? AND Version=?", id, version)
+	}
+
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+
+	if n == 0 {
+		return m.conflict(ctx, id)
+	}
+
+	return nil
+}
+
+// conflict returns the reason a conditional write to the kitten with id did
+// not change any rows, ErrNotFound when it is missing and otherwise
+// ErrVersionMismatch
+func (m *MySQLStore) conflict(ctx context.Context, id string) error {
+	if _, err := m.Get(ctx, id); err != nil {
+		return err
+	}
+
+	return ErrVersionMismatch
 }
 
 // DeleteAllKittens deletes all the kittens from the datastore
 func (m *MySQLStore) DeleteAllKittens() {
 	m.session.Exec("DELETE FROM Kittens")
+	m.invalidateTerms()
 }
 
-// InsertKittens inserts a slice of kittens into the datastore
+// InsertKittens inserts a slice of kittens into the datastore, either every
+// kitten is inserted or none are
 func (m *MySQLStore) InsertKittens(kittens []Kitten) error {
-	for _, kitten := range kittens {
-		_, err := m.session.Exec("INSERT INTO Kittens (Id, Name, Weight) VALUES (?, ?, ?)",
-			kitten.Id,
-			kitten.Name,
-			kitten.Weight,
-		)
+	result, err := m.Import(context.Background(), kittens, ImportOptions{})
+	if err != nil {
+		return err
+	}
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("kitten %d not inserted: %s", result.Errors[0].Row, result.Errors[0].Error)
+	}
+
+	return nil
+}
+
+// Import writes kittens in batches of multi-row inserts inside a single
+// transaction, existing kittens are replaced when upserting and are
+// otherwise reported as errors
+func (m *MySQLStore) Import(ctx context.Context, kittens []Kitten, options ImportOptions) (ImportResult, error) {
+	defer m.invalidateTerms()
 
+	rows, errors := prepareImport(kittens)
+	result := ImportResult{Errors: errors}
+
+	tx, err := m.session.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportResult{}, storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(rows); start += ImportBatchSize {
+		end := start + ImportBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		batch := make([]Kitten, 0, end-start)
+		for _, row := range rows[start:end] {
+			batch = append(batch, kittens[row])
+		}
+
+		if !options.Upsert {
+			// the existing rows are locked so that they can not be created
+			// by another writer before the insert
+			existing, err := m.existingIds(ctx, tx, batch)
+			if err != nil {
+				return ImportResult{}, err
+			}
+
+			batch = batch[:0]
+			for _, row := range rows[start:end] {
+				if existing[kittens[row].Id] {
+					result.Errors = append(result.Errors, RowError{Row: row, Id: kittens[row].Id, Error: ErrExists.Error()})
+					continue
+				}
+
+				batch = append(batch, kittens[row])
+			}
+
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		insert := &sqlBuilder{}
+		compileInsert(insert, batch, options.Upsert)
+
+		written, err := tx.ExecContext(ctx, insert.String(), insert.args...) //nolint:safesql
+		if e, ok := err.(*mysql.MySQLError); ok && e.Number == errDuplicateKey {
+			return ImportResult{}, ErrExists
+		}
+		if err != nil {
+			return ImportResult{}, storeError(ctx, err)
+		}
+
+		// MySQL counts an inserted row once and an updated row twice
+		affected, err := written.RowsAffected()
+		if err != nil {
+			return ImportResult{}, storeError(ctx, err)
+		}
+
+		updated := int(affected) - len(batch)
+		result.Updated += updated
+		result.Created += len(batch) - updated
+
+		stored, err := m.kittensById(ctx, tx, batch)
 		if err != nil {
-			return err
+			return ImportResult{}, err
 		}
+
+		result.Kittens = append(result.Kittens, stored...)
 	}
 
-	return nil
+	if err := tx.Commit(); err != nil {
+		return ImportResult{}, storeError(ctx, err)
+	}
+
+	sortRowErrors(result.Errors)
+	return result, nil
+}
+
+// existingIds returns the ids of the kittens in batch which are already
+// stored, locking them until the end of tx
+func (m *MySQLStore) existingIds(ctx context.Context, tx *sql.Tx, batch []Kitten) (map[string]bool, error) {
+	b := &sqlBuilder{}
+	b.write("SELECT Id FROM Kittens WHERE Id IN ")
+	compileIds(b, batch)
+	b.write(" FOR UPDATE")
+
+	rows, err := tx.QueryContext(ctx, b.String(), b.args...) //nolint:safesql
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, storeError(ctx, err)
+		}
+
+		existing[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	return existing, nil
+}
+
+// kittensById reads back the kittens in batch so that their versions are
+// known
+func (m *MySQLStore) kittensById(ctx context.Context, tx *sql.Tx, batch []Kitten) ([]Kitten, error) {
+	b := &sqlBuilder{}
+	b.write("SELECT Id, Name, Weight, Version FROM Kittens WHERE Id IN ")
+	compileIds(b, batch)
+
+	rows, err := tx.QueryContext(ctx, b.String(), b.args...) //nolint:safesql
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	var kittens []Kitten
+	for rows.Next() {
+		kitten := Kitten{}
+		if err := rows.Scan(&kitten.Id, &kitten.Name, &kitten.Weight, &kitten.Version); err != nil {
+			return nil, storeError(ctx, err)
+		}
+
+		kittens = append(kittens, kitten)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	return kittens, nil
+}
+
+// Migrator returns a Migrator which manages the schema of the MySQL instance
+func (m *MySQLStore) Migrator() *migrations.Migrator {
+	return migrations.NewMigrator(m.session)
+}
+
+// Migrate applies any schema migrations which have not yet been applied
+func (m *MySQLStore) Migrate(ctx context.Context) error {
+	defer m.invalidateTerms()
+
+	_, err := m.Migrator().Up(ctx)
+	return err
 }
 
-// CreateSchema creates the initial datbase schema
-func (m *MySQLStore) CreateSchema() {
-	m.session.Exec("DROP TABLE Kittens")
-	m.session.Exec("CREATE TABLE Kittens (Id varchar(50), Name varchar(200), Weight int)")
+// Close closes the connection pool, queries which are in progress are
+// allowed to complete
+func (m *MySQLStore) Close() error {
+	return m.session.Close()
 }
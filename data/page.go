@@ -0,0 +1,104 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+const (
+	// DefaultLimit is the number of hits returned when a query has no limit
+	DefaultLimit = 10
+	// MaxLimit is the largest number of hits which can be returned at once
+	MaxLimit = 100
+)
+
+// ErrInvalidCursor is returned when a cursor can not be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Result is a page of hits returned by a search
+type Result struct {
+	Hits []Hit
+	// Total is the number of hits matching the query across every page
+	Total int
+	// Next is the position after the last hit in this page, it is nil when
+	// there are no more hits
+	Next *Cursor
+	// Aggregations holds the result of each of the query's aggregations keyed
+	// by name, they are computed over every hit rather than just this page
+	Aggregations map[string]AggregationResult
+}
+
+// Cursor marks the position of a hit in the results of a query, a query
+// with a cursor returns the hits following it in the query's sort order
+type Cursor struct {
+	Score  float64 `json:"s,omitempty"`
+	Name   string  `json:"n,omitempty"`
+	Weight float32 `json:"w,omitempty"`
+	Id     string  `json:"i"`
+}
+
+// CursorFor returns the cursor positioned at h
+func CursorFor(h Hit) *Cursor {
+	return &Cursor{Score: h.Score, Name: h.Name, Weight: h.Weight, Id: h.Id}
+}
+
+// Encode returns the cursor as an opaque string safe to use in a URL
+func (c *Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor decodes a cursor produced by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	c := &Cursor{}
+	if err := json.Unmarshal(b, c); err != nil || c.Id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// hit returns the position marked by the cursor as a Hit
+func (c *Cursor) hit() Hit {
+	return Hit{Kitten: Kitten{Id: c.Id, Name: c.Name, Weight: c.Weight}, Score: c.Score}
+}
+
+// Paginate orders hits by the query's sort fields and returns the page
+// selected by its cursor, offset and limit, it is used by stores which hold
+// every match in memory
+func Paginate(hits []Hit, q Query) Result {
+	fields := q.sortFields()
+	sortHits(hits, fields)
+
+	result := Result{Total: len(hits), Aggregations: Aggregate(hits, q.Aggregations)}
+
+	start := 0
+	if q.After != nil {
+		after := q.After.hit()
+		start = sort.Search(len(hits), func(n int) bool {
+			return compareHits(after, hits[n], fields) < 0
+		})
+	}
+
+	start += q.Offset
+	if start >= len(hits) {
+		return result
+	}
+
+	end := start + q.limit()
+	if end < len(hits) {
+		result.Next = CursorFor(hits[end-1])
+	} else {
+		end = len(hits)
+	}
+
+	result.Hits = hits[start:end]
+	return result
+}
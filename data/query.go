@@ -0,0 +1,141 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
+)
+
+// Query describes a search executed against a Store
+type Query struct {
+	// Text is the query as entered by the user
+	Text string
+	// Expr is the parsed form of Text, when it is nil Text is parsed by the
+	// Store executing the query
+	Expr query.Node
+	// Fuzziness is the maximum number of edits allowed when matching terms
+	Fuzziness Fuzziness
+	// Limit is the maximum number of hits to return, DefaultLimit is used
+	// when it is zero
+	Limit int
+	// Offset is the number of hits to skip
+	Offset int
+	// After returns the hits following the cursor, it is more efficient than
+	// Offset for deep pages
+	After *Cursor
+	// Sort orders the hits, ties are broken by ascending id, when it is empty
+	// hits are ordered by descending relevance
+	Sort []SortField
+	// Aggregations are computed over every hit and returned with the page
+	Aggregations []Aggregation
+}
+
+func (q Query) sortFields() []SortField {
+	if len(q.Sort) == 0 {
+		return defaultSort
+	}
+
+	return q.Sort
+}
+
+func (q Query) limit() int {
+	if q.Limit <= 0 {
+		return DefaultLimit
+	}
+
+	return q.Limit
+}
+
+// Node returns the syntax tree for the query, parsing Text if Expr is unset
+func (q Query) Node() (query.Node, error) {
+	if q.Expr != nil {
+		return q.Expr, nil
+	}
+
+	return query.Parse(q.Text)
+}
+
+// WeightRange returns r with its bounds rounded to the float32 precision
+// kitten weights are held with so that weight:[12.3 TO *] includes a kitten
+// weighing 12.3
+func WeightRange(r query.Range) query.Range {
+	if r.Min != nil {
+		min := float64(float32(*r.Min))
+		r.Min = &min
+	}
+
+	if r.Max != nil {
+		max := float64(float32(*r.Max))
+		r.Max = &max
+	}
+
+	return r
+}
+
+// Fuzziness is the maximum Damerau-Levenshtein distance allowed between a
+// query term and a stored term for them to match
+type Fuzziness int
+
+const (
+	// FuzzinessNone only matches terms exactly
+	FuzzinessNone Fuzziness = 0
+	// FuzzinessAuto scales the allowed edits with the length of the term
+	FuzzinessAuto Fuzziness = -1
+	// MaxFuzziness is the largest number of edits which may be requested
+	MaxFuzziness Fuzziness = 2
+)
+
+// Distance returns the number of edits allowed for term, with FuzzinessAuto
+// terms of up to two characters must match exactly, terms of up to five
+// characters allow one edit and longer terms allow two
+func (f Fuzziness) Distance(term string) int {
+	if f != FuzzinessAuto {
+		return int(f)
+	}
+
+	switch n := len([]rune(term)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Valid returns true when f is FuzzinessAuto or between zero and MaxFuzziness
+func (f Fuzziness) Valid() bool {
+	return f == FuzzinessAuto || (f >= FuzzinessNone && f <= MaxFuzziness)
+}
+
+// UnmarshalJSON accepts either a number of edits or the string "auto"
+func (f *Fuzziness) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		if strings.ToLower(s) != "auto" {
+			return fmt.Errorf("invalid fuzziness %q, expected a number or auto", s)
+		}
+
+		*f = FuzzinessAuto
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("invalid fuzziness %s, expected a number or auto", b)
+	}
+
+	*f = Fuzziness(n)
+	return nil
+}
+
+// MarshalJSON encodes FuzzinessAuto as "auto" and other values as numbers
+func (f Fuzziness) MarshalJSON() ([]byte, error) {
+	if f == FuzzinessAuto {
+		return []byte(`"auto"`), nil
+	}
+
+	return json.Marshal(int(f))
+}
@@ -0,0 +1,139 @@
+// Package query parses the search query language into an abstract syntax
+// tree which is executed by each data.Store implementation.
+//
+// The language supports terms, quoted phrases, field scoped terms such as
+// name:felix, numeric ranges such as weight:[10 TO 25] and the boolean
+// operators AND, OR and NOT with parentheses for grouping. Terms which are
+// not separated by an operator must all match.
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fields which can be used to scope a term, phrase or range
+const (
+	FieldAny    = ""
+	FieldId     = "id"
+	FieldName   = "name"
+	FieldWeight = "weight"
+)
+
+// TextFields are the fields matched by a term or phrase without a field
+var TextFields = []string{FieldId, FieldName}
+
+// Node is an element of a parsed query
+type Node interface {
+	String() string
+}
+
+// And matches documents which match every child
+type And struct {
+	Children []Node
+}
+
+// Or matches documents which match any child
+type Or struct {
+	Children []Node
+}
+
+// Not matches documents which do not match Child
+type Not struct {
+	Child Node
+}
+
+// Term matches documents containing Value in Field, or in any text field when
+// Field is FieldAny
+type Term struct {
+	Field string
+	Value string
+}
+
+// Phrase matches documents containing Value as consecutive terms in Field,
+// or in any text field when Field is FieldAny
+type Phrase struct {
+	Field string
+	Value string
+}
+
+// Range matches documents where the numeric Field lies between Min and Max,
+// a nil bound is open ended
+type Range struct {
+	Field      string
+	Min        *float64
+	Max        *float64
+	IncludeMin bool
+	IncludeMax bool
+}
+
+func (n And) String() string {
+	return join(n.Children, " AND ")
+}
+
+func (n Or) String() string {
+	return join(n.Children, " OR ")
+}
+
+func (n Not) String() string {
+	return "NOT " + n.Child.String()
+}
+
+func (n Term) String() string {
+	return scope(n.Field) + n.Value
+}
+
+func (n Phrase) String() string {
+	return scope(n.Field) + strconv.Quote(n.Value)
+}
+
+func (n Range) String() string {
+	open, close := "{", "}"
+	if n.IncludeMin {
+		open = "["
+	}
+	if n.IncludeMax {
+		close = "]"
+	}
+
+	return fmt.Sprintf("%s%s%s TO %s%s", scope(n.Field), open, bound(n.Min), bound(n.Max), close)
+}
+
+// Contains returns true when v lies within the range
+func (n Range) Contains(v float64) bool {
+	if n.Min != nil && (v < *n.Min || (v == *n.Min && !n.IncludeMin)) {
+		return false
+	}
+
+	if n.Max != nil && (v > *n.Max || (v == *n.Max && !n.IncludeMax)) {
+		return false
+	}
+
+	return true
+}
+
+func join(nodes []Node, sep string) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = n.String()
+	}
+
+	return "(" + strings.Join(parts, sep) + ")"
+}
+
+func scope(field string) string {
+	if field == FieldAny {
+		return ""
+	}
+
+	return field + ":"
+}
+
+func bound(v *float64) string {
+	if v == nil {
+		return "*"
+	}
+
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
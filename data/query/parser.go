@@ -0,0 +1,424 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned when a query can not be parsed
+type SyntaxError struct {
+	// Position is the byte offset in the query at which the error occurred
+	Position int
+	Message  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Position, e.Message)
+}
+
+type itemType int
+
+const (
+	itemEOF itemType = iota
+	itemLeftParen
+	itemRightParen
+	itemAnd
+	itemOr
+	itemNot
+	itemTerm
+	itemPhrase
+	itemRange
+)
+
+type item struct {
+	typ      itemType
+	position int
+	field    string
+	value    string
+}
+
+// Parse parses a query into its syntax tree
+func Parse(q string) (Node, error) {
+	items, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{items: items}
+	if p.peek().typ == itemEOF {
+		return nil, &SyntaxError{Position: 0, Message: "empty query"}
+	}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next.typ != itemEOF {
+		return nil, &SyntaxError{Position: next.position, Message: "unexpected " + describe(next)}
+	}
+
+	return n, nil
+}
+
+// MustParse is like Parse but panics when the query is invalid, it is
+// intended for queries which are known to be valid such as those in tests
+func MustParse(q string) Node {
+	n, err := Parse(q)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+type parser struct {
+	items []item
+	pos   int
+}
+
+func (p *parser) peek() item {
+	return p.items[p.pos]
+}
+
+func (p *parser) next() item {
+	i := p.items[p.pos]
+	if i.typ != itemEOF {
+		p.pos++
+	}
+
+	return i
+}
+
+func (p *parser) parseOr() (Node, error) {
+	var children []Node
+
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, n)
+		if p.peek().typ != itemOr {
+			break
+		}
+
+		p.next()
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return Or{Children: children}, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	var children []Node
+
+	for {
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		children = append(children, n)
+
+		// terms which are not separated by an operator are implicitly joined
+		// with AND
+		switch p.peek().typ {
+		case itemAnd:
+			p.next()
+			continue
+		case itemNot, itemLeftParen, itemTerm, itemPhrase, itemRange:
+			continue
+		}
+
+		break
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return And{Children: children}, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	if p.peek().typ != itemNot {
+		return p.parsePrimary()
+	}
+
+	p.next()
+	n, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	return Not{Child: n}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	i := p.next()
+
+	switch i.typ {
+	case itemLeftParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.typ != itemRightParen {
+			return nil, &SyntaxError{Position: closing.position, Message: "expected ) but found " + describe(closing)}
+		}
+
+		return n, nil
+	case itemTerm:
+		return term(i)
+	case itemPhrase:
+		return phrase(i)
+	case itemRange:
+		return parseRange(i)
+	}
+
+	return nil, &SyntaxError{Position: i.position, Message: "unexpected " + describe(i)}
+}
+
+func term(i item) (Node, error) {
+	switch i.field {
+	case FieldAny, FieldId, FieldName:
+		return Term{Field: i.field, Value: i.value}, nil
+	case FieldWeight:
+		// a single weight is a range containing only that weight
+		v, err := strconv.ParseFloat(i.value, 64)
+		if err != nil {
+			return nil, &SyntaxError{Position: i.position, Message: "weight must be a number"}
+		}
+
+		return Range{Field: FieldWeight, Min: &v, Max: &v, IncludeMin: true, IncludeMax: true}, nil
+	}
+
+	return nil, unknownField(i)
+}
+
+func phrase(i item) (Node, error) {
+	if strings.TrimSpace(i.value) == "" {
+		return nil, &SyntaxError{Position: i.position, Message: "empty phrase"}
+	}
+
+	switch i.field {
+	case FieldAny, FieldId, FieldName:
+		return Phrase{Field: i.field, Value: i.value}, nil
+	case FieldWeight:
+		return nil, &SyntaxError{Position: i.position, Message: "weight must be a number"}
+	}
+
+	return nil, unknownField(i)
+}
+
+func parseRange(i item) (Node, error) {
+	if i.field != FieldWeight {
+		if i.field == FieldAny || i.field == FieldId || i.field == FieldName {
+			return nil, &SyntaxError{Position: i.position, Message: "ranges are only supported on weight"}
+		}
+
+		return nil, unknownField(i)
+	}
+
+	r := Range{
+		Field:      i.field,
+		IncludeMin: i.value[0] == '[',
+		IncludeMax: i.value[len(i.value)-1] == ']',
+	}
+
+	bounds := strings.Fields(i.value[1 : len(i.value)-1])
+	if len(bounds) != 3 || bounds[1] != "TO" {
+		return nil, &SyntaxError{Position: i.position, Message: "range must be in the form [min TO max]"}
+	}
+
+	var err error
+	if r.Min, err = parseBound(bounds[0]); err != nil {
+		return nil, &SyntaxError{Position: i.position, Message: "range bounds must be numbers or *"}
+	}
+	if r.Max, err = parseBound(bounds[2]); err != nil {
+		return nil, &SyntaxError{Position: i.position, Message: "range bounds must be numbers or *"}
+	}
+
+	return r, nil
+}
+
+func parseBound(s string) (*float64, error) {
+	if s == "*" {
+		return nil, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+func unknownField(i item) error {
+	return &SyntaxError{Position: i.position, Message: "unknown field " + i.field}
+}
+
+func describe(i item) string {
+	switch i.typ {
+	case itemEOF:
+		return "end of query"
+	case itemLeftParen:
+		return "("
+	case itemRightParen:
+		return ")"
+	case itemAnd:
+		return "AND"
+	case itemOr:
+		return "OR"
+	case itemNot:
+		return "NOT"
+	}
+
+	return strconv.Quote(i.value)
+}
+
+// lex splits the query into items
+func lex(q string) ([]item, error) {
+	var items []item
+
+	for pos := 0; pos < len(q); {
+		c := q[pos]
+
+		switch r, size := utf8.DecodeRuneInString(q[pos:]); {
+		case unicode.IsSpace(r):
+			pos += size
+		case c == '(':
+			items = append(items, item{typ: itemLeftParen, position: pos})
+			pos++
+		case c == ')':
+			items = append(items, item{typ: itemRightParen, position: pos})
+			pos++
+		case c == '"':
+			value, end, err := lexPhrase(q, pos)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item{typ: itemPhrase, position: pos, value: value})
+			pos = end
+		default:
+			i, end, err := lexWord(q, pos)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, i)
+			pos = end
+		}
+	}
+
+	return append(items, item{typ: itemEOF, position: len(q)}), nil
+}
+
+// lexWord reads an operator, a term or a field scoped term, phrase or range
+func lexWord(q string, start int) (item, int, error) {
+	pos := start
+	for pos < len(q) {
+		r, size := utf8.DecodeRuneInString(q[pos:])
+		if isWordBoundary(r) {
+			break
+		}
+
+		if r == ':' && pos > start {
+			return lexScoped(q, start, pos)
+		}
+
+		pos += size
+	}
+
+	word := q[start:pos]
+	switch word {
+	case "AND":
+		return item{typ: itemAnd, position: start}, pos, nil
+	case "OR":
+		return item{typ: itemOr, position: start}, pos, nil
+	case "NOT":
+		return item{typ: itemNot, position: start}, pos, nil
+	}
+
+	return item{typ: itemTerm, position: start, value: word}, pos, nil
+}
+
+// lexScoped reads the value following the field name at q[start:colon]
+func lexScoped(q string, start, colon int) (item, int, error) {
+	field := strings.ToLower(q[start:colon])
+	pos := colon + 1
+
+	if pos >= len(q) || isWordBoundary(runeAt(q, pos)) && q[pos] != '"' {
+		return item{}, 0, &SyntaxError{Position: pos, Message: "expected a value after " + field + ":"}
+	}
+
+	switch q[pos] {
+	case '"':
+		value, end, err := lexPhrase(q, pos)
+		if err != nil {
+			return item{}, 0, err
+		}
+
+		return item{typ: itemPhrase, position: start, field: field, value: value}, end, nil
+	case '[', '{':
+		end := strings.IndexAny(q[pos:], "]}")
+		if end < 0 {
+			return item{}, 0, &SyntaxError{Position: pos, Message: "unterminated range"}
+		}
+
+		end += pos + 1
+		return item{typ: itemRange, position: start, field: field, value: q[pos:end]}, end, nil
+	}
+
+	end := pos
+	for end < len(q) && !isWordBoundary(runeAt(q, end)) {
+		_, size := utf8.DecodeRuneInString(q[end:])
+		end += size
+	}
+
+	return item{typ: itemTerm, position: start, field: field, value: q[pos:end]}, end, nil
+}
+
+// lexPhrase reads a quoted phrase starting at q[start], quotes inside the
+// phrase are escaped with a backslash
+func lexPhrase(q string, start int) (string, int, error) {
+	var value []byte
+
+	for pos := start + 1; pos < len(q); pos++ {
+		switch q[pos] {
+		case '\\':
+			if pos+1 < len(q) {
+				pos++
+				value = append(value, q[pos])
+			}
+		case '"':
+			return string(value), pos + 1, nil
+		default:
+			value = append(value, q[pos])
+		}
+	}
+
+	return "", 0, &SyntaxError{Position: start, Message: "unterminated phrase"}
+}
+
+func isWordBoundary(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+// runeAt decodes the UTF-8 character starting at q[pos], continuation bytes
+// must not be mistaken for characters of their own
+func runeAt(q string, pos int) rune {
+	r, _ := utf8.DecodeRuneInString(q[pos:])
+	return r
+}
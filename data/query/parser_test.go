@@ -0,0 +1,89 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJoinsTermsWithImplicitAnd(t *testing.T) {
+	n, err := Parse("Fat Freddy's Cat")
+
+	assert.Nil(t, err)
+	assert.Equal(t, And{Children: []Node{
+		Term{Value: "Fat"},
+		Term{Value: "Freddy's"},
+		Term{Value: "Cat"},
+	}}, n)
+}
+
+func TestParseKeepsNonASCIITermsWhole(t *testing.T) {
+	// à and Å are encoded with the bytes 0xa0 and 0x85 which are spaces
+	// when read as Latin-1
+	n, err := Parse("voilà name:Åsa\u00a0café")
+
+	assert.Nil(t, err)
+	assert.Equal(t, And{Children: []Node{
+		Term{Value: "voilà"},
+		Term{Field: FieldName, Value: "Åsa"},
+		Term{Value: "café"},
+	}}, n)
+}
+
+func TestParseGivesNotPrecedenceOverAndOverOr(t *testing.T) {
+	n, err := Parse("felix OR tom AND NOT garfield")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "(felix OR (tom AND NOT garfield))", n.String())
+}
+
+func TestParseGroupsWithParentheses(t *testing.T) {
+	n, err := Parse("(felix OR tom) AND name:\"fat freddy\"")
+
+	assert.Nil(t, err)
+	assert.Equal(t, `((felix OR tom) AND name:"fat freddy")`, n.String())
+}
+
+func TestParseRanges(t *testing.T) {
+	n, err := Parse("weight:[10 TO 25}")
+
+	assert.Nil(t, err)
+	r := n.(Range)
+	assert.True(t, r.Contains(10))
+	assert.True(t, r.Contains(24.9))
+	assert.False(t, r.Contains(25))
+	assert.False(t, r.Contains(9))
+}
+
+func TestParseOpenEndedRanges(t *testing.T) {
+	r := MustParse("weight:[20 TO *]").(Range)
+
+	assert.Nil(t, r.Max)
+	assert.True(t, r.Contains(1000))
+	assert.False(t, r.Contains(19))
+}
+
+func TestParseSingleWeightIsAnExactRange(t *testing.T) {
+	r := MustParse("weight:20").(Range)
+
+	assert.True(t, r.Contains(20))
+	assert.False(t, r.Contains(20.5))
+}
+
+func TestParseReturnsSyntaxErrors(t *testing.T) {
+	for _, q := range []string{
+		"",
+		"(felix",
+		"felix)",
+		"felix AND",
+		"colour:black",
+		"name:[1 TO 2]",
+		"weight:[1 2]",
+		"weight:heavy",
+		`"fat freddy`,
+	} {
+		_, err := Parse(q)
+
+		assert.IsType(t, &SyntaxError{}, err, q)
+	}
+}
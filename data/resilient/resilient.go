@@ -0,0 +1,353 @@
+// Package resilient provides a data.Store which protects the service from a
+// slow or failing store with per-call timeouts, retries and a circuit
+// breaker.
+package resilient
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+// State is the state of the circuit breaker
+type State string
+
+// The circuit is closed while the store is healthy, it opens after repeated
+// failures and calls are refused until it is half-open, when a single trial
+// call decides whether it closes or opens again
+const (
+	StateClosed   State = "closed"
+	StateOpen     State = "open"
+	StateHalfOpen State = "half-open"
+)
+
+// gauges name the statsd gauge set to 1 while the circuit is in each state
+// and 0 otherwise
+var gauges = map[State]string{
+	StateClosed:   "search.store.circuit.closed",
+	StateOpen:     "search.store.circuit.open",
+	StateHalfOpen: "search.store.circuit.halfopen",
+}
+
+// Options control the timeouts, retries and circuit breaker of a Store
+type Options struct {
+	// Timeout bounds each attempt of a call, All reads every kitten so a
+	// deadline set by its caller replaces the Timeout
+	Timeout time.Duration
+	// ImportTimeout bounds each call to Import, which writes many kittens
+	ImportTimeout time.Duration
+	// Retries is how many times a read failing with a transient error is
+	// tried again, writes are never retried as they may have been applied
+	Retries int
+	// Backoff is the delay before the first retry, it doubles for each
+	// following retry up to MaxBackoff and is jittered so that instances do
+	// not retry in step
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// FailureThreshold is the number of consecutive failures which open the
+	// circuit
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before a trial call is
+	// allowed
+	OpenTimeout time.Duration
+}
+
+// DefaultOptions returns the options used by the search service
+func DefaultOptions() Options {
+	return Options{
+		Timeout:          2 * time.Second,
+		ImportTimeout:    time.Minute,
+		Retries:          2,
+		Backoff:          50 * time.Millisecond,
+		MaxBackoff:       time.Second,
+		FailureThreshold: 5,
+		OpenTimeout:      10 * time.Second,
+	}
+}
+
+// Backend is a store which can be searched and written to
+type Backend interface {
+	data.Store
+	data.Writer
+}
+
+// Store is a data.Store and data.Writer which calls a Backend. Reads which
+// fail with an error for which data.IsTransient is true are retried, and
+// once FailureThreshold calls in a row have failed every call is refused
+// with data.ErrUnavailable until OpenTimeout has passed.
+type Store struct {
+	backend Backend
+	options Options
+	statsd  *statsd.Client
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	opened   time.Time
+	// trial is true while the single call allowed by a half-open circuit is
+	// in progress
+	trial bool
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// State returns the current state of the circuit breaker
+func (s *Store) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+// Search implements data.Store
+func (s *Store) Search(ctx context.Context, q data.Query) (data.Result, error) {
+	var result data.Result
+	err := s.call(ctx, true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+		defer cancel()
+
+		var err error
+		result, err = s.backend.Search(ctx, q)
+		return err
+	})
+
+	return result, err
+}
+
+// All implements data.Store
+func (s *Store) All(ctx context.Context) ([]data.Kitten, error) {
+	var kittens []data.Kitten
+	err := s.call(ctx, true, func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.options.Timeout)
+			defer cancel()
+		}
+
+		var err error
+		kittens, err = s.backend.All(ctx)
+		return err
+	})
+
+	return kittens, err
+}
+
+// Get implements data.Writer
+func (s *Store) Get(ctx context.Context, id string) (data.Kitten, error) {
+	var kitten data.Kitten
+	err := s.call(ctx, true, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+		defer cancel()
+
+		var err error
+		kitten, err = s.backend.Get(ctx, id)
+		return err
+	})
+
+	return kitten, err
+}
+
+// Create implements data.Writer
+func (s *Store) Create(ctx context.Context, k data.Kitten) (data.Kitten, error) {
+	err := s.call(ctx, false, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+		defer cancel()
+
+		var err error
+		k, err = s.backend.Create(ctx, k)
+		return err
+	})
+
+	return k, err
+}
+
+// Update implements data.Writer
+func (s *Store) Update(ctx context.Context, k data.Kitten, version int64) (data.Kitten, error) {
+	err := s.call(ctx, false, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+		defer cancel()
+
+		var err error
+		k, err = s.backend.Update(ctx, k, version)
+		return err
+	})
+
+	return k, err
+}
+
+// Delete implements data.Writer
+func (s *Store) Delete(ctx context.Context, id string, version int64) error {
+	return s.call(ctx, false, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.options.Timeout)
+		defer cancel()
+
+		return s.backend.Delete(ctx, id, version)
+	})
+}
+
+// Import implements data.Importer when the Backend does
+func (s *Store) Import(ctx context.Context, kittens []data.Kitten, options data.ImportOptions) (data.ImportResult, error) {
+	importer, ok := s.backend.(data.Importer)
+	if !ok {
+		return data.ImportResult{}, data.ErrImportUnsupported
+	}
+
+	var result data.ImportResult
+	err := s.call(ctx, false, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, s.options.ImportTimeout)
+		defer cancel()
+
+		var err error
+		result, err = importer.Import(ctx, kittens, options)
+		return err
+	})
+
+	return result, err
+}
+
+// call runs f when the circuit allows it, retrying transient errors with
+// backoff when retry is true
+func (s *Store) call(ctx context.Context, retry bool, f func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		if !s.allow() {
+			s.statsd.Incr("search.store.rejected", nil, 1)
+			return data.ErrUnavailable
+		}
+
+		err := f(ctx)
+		if err == data.ErrTimeout {
+			s.statsd.Incr("search.store.timeout", nil, 1)
+		}
+		s.record(ctx, err)
+
+		if !retry || !data.IsTransient(err) || attempt >= s.options.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		s.statsd.Incr("search.store.retry", nil, 1)
+		if err := s.sleep(ctx, s.backoff(attempt)); err != nil {
+			if err == context.DeadlineExceeded {
+				return data.ErrTimeout
+			}
+			return err
+		}
+	}
+}
+
+// allow returns true when a call may be made to the backend
+func (s *Store) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case StateOpen:
+		if s.now().Sub(s.opened) < s.options.OpenTimeout {
+			return false
+		}
+
+		s.setState(StateHalfOpen)
+	case StateClosed:
+		return true
+	}
+
+	if s.trial {
+		return false
+	}
+
+	s.trial = true
+	return true
+}
+
+// record updates the circuit with the outcome of a call made with ctx.
+// Transient errors are failures while any other error shows the backend is
+// responding, a call cancelled or timed out by its caller tells nothing
+// about the backend.
+func (s *Store) record(ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trial := s.trial
+	s.trial = false
+
+	switch {
+	case err == context.Canceled, ctx.Err() != nil:
+	case data.IsTransient(err):
+		s.failures++
+		if trial || s.failures >= s.options.FailureThreshold {
+			s.opened = s.now()
+			s.setState(StateOpen)
+		}
+	default:
+		s.failures = 0
+		s.setState(StateClosed)
+	}
+}
+
+// setState changes the state of the circuit, it must be called with mu held
+func (s *Store) setState(state State) {
+	if s.state == state {
+		return
+	}
+
+	log.Printf("Store circuit is %s", state)
+	s.state = state
+	s.gauges()
+}
+
+// gauges sets the gauge of every state
+func (s *Store) gauges() {
+	for state, gauge := range gauges {
+		value := 0.0
+		if state == s.state {
+			value = 1
+		}
+
+		s.statsd.Gauge(gauge, value, nil, 1)
+	}
+}
+
+// backoff returns the delay before retry attempt+1, it is between half and
+// all of the exponential backoff
+func (s *Store) backoff(attempt int) time.Duration {
+	d := s.options.Backoff << uint(attempt)
+	if d > s.options.MaxBackoff || d <= 0 {
+		d = s.options.MaxBackoff
+	}
+
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// New returns a Store which calls backend, the circuit starts closed
+func New(backend Backend, options Options, statsd *statsd.Client) *Store {
+	s := &Store{
+		backend: backend,
+		options: options,
+		statsd:  statsd,
+		state:   StateClosed,
+		now:     time.Now,
+		sleep:   sleep,
+	}
+	s.gauges()
+
+	return s
+}
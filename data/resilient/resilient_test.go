@@ -0,0 +1,192 @@
+package resilient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptedStore returns the next of its errors from every call, then nil,
+// and records whether each call had a deadline
+type scriptedStore struct {
+	data.MockStore
+	errors    []error
+	calls     int
+	deadlines int
+}
+
+func (s *scriptedStore) next(ctx context.Context) error {
+	s.calls++
+	if _, ok := ctx.Deadline(); ok {
+		s.deadlines++
+	}
+
+	if len(s.errors) == 0 {
+		return nil
+	}
+
+	err := s.errors[0]
+	s.errors = s.errors[1:]
+	return err
+}
+
+func (s *scriptedStore) Search(ctx context.Context, q data.Query) (data.Result, error) {
+	return data.Result{Total: 1}, s.next(ctx)
+}
+
+func (s *scriptedStore) All(ctx context.Context) ([]data.Kitten, error) {
+	return nil, s.next(ctx)
+}
+
+func (s *scriptedStore) Get(ctx context.Context, id string) (data.Kitten, error) {
+	return data.Kitten{Id: id}, s.next(ctx)
+}
+
+func (s *scriptedStore) Create(ctx context.Context, k data.Kitten) (data.Kitten, error) {
+	return k, s.next(ctx)
+}
+
+func (s *scriptedStore) Update(ctx context.Context, k data.Kitten, version int64) (data.Kitten, error) {
+	return k, s.next(ctx)
+}
+
+func (s *scriptedStore) Delete(ctx context.Context, id string, version int64) error {
+	return s.next(ctx)
+}
+
+func (s *scriptedStore) Import(ctx context.Context, kittens []data.Kitten, options data.ImportOptions) (data.ImportResult, error) {
+	return data.ImportResult{}, s.next(ctx)
+}
+
+func TestTransientErrorsAreRetried(t *testing.T) {
+	backend := &scriptedStore{errors: []error{data.ErrUnavailable, data.ErrTimeout}}
+	s, _ := newStore(backend)
+
+	result, err := s.Search(context.Background(), data.Query{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, 3, backend.calls)
+	assert.Equal(t, StateClosed, s.State())
+}
+
+func TestRetriesAreBounded(t *testing.T) {
+	backend := &scriptedStore{errors: []error{data.ErrUnavailable, data.ErrUnavailable, data.ErrUnavailable, data.ErrUnavailable}}
+	s, _ := newStore(backend)
+
+	_, err := s.All(context.Background())
+
+	assert.Equal(t, data.ErrUnavailable, err)
+	assert.Equal(t, 3, backend.calls)
+}
+
+func TestOtherErrorsAndWritesAreNotRetried(t *testing.T) {
+	backend := &scriptedStore{errors: []error{data.ErrNotFound, data.ErrUnavailable}}
+	s, _ := newStore(backend)
+
+	_, err := s.Search(context.Background(), data.Query{})
+	assert.Equal(t, data.ErrNotFound, err)
+
+	_, err = s.Create(context.Background(), data.Kitten{Id: "1"})
+	assert.Equal(t, data.ErrUnavailable, err)
+
+	assert.Equal(t, 2, backend.calls)
+}
+
+func TestEveryCallIsBoundedByTheTimeout(t *testing.T) {
+	backend := &scriptedStore{}
+	s, _ := newStore(backend)
+
+	s.Search(context.Background(), data.Query{})
+	s.All(context.Background())
+	s.Get(context.Background(), "1")
+	s.Create(context.Background(), data.Kitten{Id: "1"})
+	s.Update(context.Background(), data.Kitten{Id: "1"}, data.AnyVersion)
+	s.Delete(context.Background(), "1", data.AnyVersion)
+	s.Import(context.Background(), nil, data.ImportOptions{})
+
+	assert.Equal(t, 7, backend.calls)
+	assert.Equal(t, 7, backend.deadlines)
+}
+
+func TestFailuresPastTheCallersDeadlineAreNotCounted(t *testing.T) {
+	backend := &scriptedStore{}
+	s, _ := newStore(backend)
+	s.options.FailureThreshold = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	backend.errors = []error{data.ErrTimeout}
+	_, err := s.Search(ctx, data.Query{})
+
+	assert.Equal(t, data.ErrTimeout, err)
+	assert.Equal(t, StateClosed, s.State())
+}
+
+func TestTheCircuitOpensAfterRepeatedFailures(t *testing.T) {
+	backend := &scriptedStore{}
+	s, now := newStore(backend)
+	s.options.Retries = 0
+
+	for i := 0; i < s.options.FailureThreshold; i++ {
+		backend.errors = []error{data.ErrUnavailable}
+		s.Search(context.Background(), data.Query{})
+	}
+	assert.Equal(t, StateOpen, s.State())
+
+	// calls are refused without reaching the backend while it is open
+	_, err := s.Search(context.Background(), data.Query{})
+	assert.Equal(t, data.ErrUnavailable, err)
+	assert.Equal(t, s.options.FailureThreshold, backend.calls)
+
+	// a failed trial call opens it again
+	*now = now.Add(s.options.OpenTimeout)
+	backend.errors = []error{data.ErrUnavailable}
+	s.Search(context.Background(), data.Query{})
+	assert.Equal(t, StateOpen, s.State())
+
+	// and a successful one closes it
+	*now = now.Add(s.options.OpenTimeout)
+	_, err = s.Search(context.Background(), data.Query{})
+	assert.Nil(t, err)
+	assert.Equal(t, StateClosed, s.State())
+}
+
+func TestHalfOpenAllowsASingleTrialCall(t *testing.T) {
+	s, now := newStore(&scriptedStore{})
+	s.state, s.opened = StateOpen, *now
+
+	*now = now.Add(s.options.OpenTimeout)
+	assert.True(t, s.allow())
+	assert.Equal(t, StateHalfOpen, s.State())
+	assert.False(t, s.allow())
+
+	// a cancelled trial tells nothing, so another call may try
+	s.record(context.Background(), context.Canceled)
+	assert.Equal(t, StateHalfOpen, s.State())
+	assert.True(t, s.allow())
+}
+
+func TestBackoffIsJitteredAndCapped(t *testing.T) {
+	s, _ := newStore(&scriptedStore{})
+
+	for attempt, max := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond} {
+		d := s.backoff(attempt)
+		assert.True(t, d >= max/2 && d <= max, "attempt %d slept %s", attempt, d)
+	}
+
+	assert.True(t, s.backoff(30) <= s.options.MaxBackoff)
+}
+
+func newStore(backend Backend) (*Store, *time.Time) {
+	now := time.Unix(1500000000, 0)
+	s := New(backend, DefaultOptions(), nil)
+	s.now = func() time.Time { return now }
+	s.sleep = func(context.Context, time.Duration) error { return nil }
+
+	return s, &now
+}
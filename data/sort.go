@@ -0,0 +1,132 @@
+package data
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Fields which search results can be sorted by
+const (
+	SortRelevance = "relevance"
+	SortName      = "name"
+	SortWeight    = "weight"
+	SortId        = "id"
+)
+
+var sortFields = map[string]bool{
+	SortRelevance: true,
+	SortName:      true,
+	SortWeight:    true,
+	SortId:        true,
+}
+
+// SortField is a single key results are ordered by
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// ValidSortField returns true when results can be sorted by field
+func ValidSortField(field string) bool {
+	return sortFields[field]
+}
+
+// defaultSort orders results by descending relevance
+var defaultSort = []SortField{{Field: SortRelevance, Descending: true}}
+
+// compareHits returns a negative number when a sorts before b, a positive
+// number when it sorts after b and zero when they are equal, hits which are
+// equal on every field are ordered by ascending id
+func compareHits(a, b Hit, fields []SortField) int {
+	for _, f := range fields {
+		c := 0
+
+		switch f.Field {
+		case SortRelevance:
+			c = compareFloat(a.Score, b.Score)
+		case SortName:
+			c = strings.Compare(CollationKey(a.Name), CollationKey(b.Name))
+			if c == 0 {
+				c = strings.Compare(a.Name, b.Name)
+			}
+		case SortWeight:
+			c = compareFloat(float64(a.Weight), float64(b.Weight))
+		case SortId:
+			c = strings.Compare(a.Id, b.Id)
+		}
+
+		if f.Descending {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return strings.Compare(a.Id, b.Id)
+}
+
+// sortHits orders hits by fields
+func sortHits(hits []Hit, fields []SortField) {
+	sort.SliceStable(hits, func(a, b int) bool {
+		return compareHits(hits[a], hits[b], fields) < 0
+	})
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// CollationKey returns a key which orders names the way a reader expects
+// rather than by byte value, case is ignored and accented Latin letters sort
+// with their base letter so "émile" sorts between "Eddie" and "Felix"
+func CollationKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if base, ok := latinBase[r]; ok {
+			return base
+		}
+
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// latinBase maps accented Latin letters to their unaccented lower case form
+var latinBase = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for base, accented := range map[rune]string{
+		'a': "àáâãäåāăąÀÁÂÃÄÅĀĂĄ",
+		'c': "çćĉċčÇĆĈĊČ",
+		'd': "ďđĎĐ",
+		'e': "èéêëēĕėęěÈÉÊËĒĔĖĘĚ",
+		'g': "ĝğġģĜĞĠĢ",
+		'h': "ĥħĤĦ",
+		'i': "ìíîïĩīĭįıÌÍÎÏĨĪĬĮİ",
+		'j': "ĵĴ",
+		'k': "ķĶ",
+		'l': "ĺļľŀłĹĻĽĿŁ",
+		'n': "ñńņňÑŃŅŇ",
+		'o': "òóôõöøōŏőÒÓÔÕÖØŌŎŐ",
+		'r': "ŕŗřŔŖŘ",
+		's': "śŝşšŚŜŞŠ",
+		't': "ţťŧŢŤŦ",
+		'u': "ùúûüũūŭůűųÙÚÛÜŨŪŬŮŰŲ",
+		'w': "ŵŴ",
+		'y': "ýÿŷÝŸŶ",
+		'z': "źżžŹŻŽ",
+	} {
+		for _, r := range accented {
+			m[r] = base
+		}
+	}
+
+	return m
+}()
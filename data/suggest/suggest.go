@@ -0,0 +1,260 @@
+package suggest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+// MaxSuggestions is the largest number of suggestions which can be returned
+// for a prefix, the best MaxSuggestions completions are precomputed for
+// every node so lookups do not need to walk the tree
+const MaxSuggestions = 10
+
+// refreshDelay batches changes so that a burst of writes rebuilds the trie
+// once
+const refreshDelay = 500 * time.Millisecond
+
+// Suggestion is a kitten name completing a prefix
+type Suggestion struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+}
+
+type node struct {
+	children map[rune]*node
+	best     []int
+}
+
+// Suggester completes prefixes to kitten names using a trie, names are
+// ranked by their weight which is the combined weight of every kitten with
+// that name. It implements data.Listener, changes are applied by rebuilding
+// the trie shortly after they are received.
+type Suggester struct {
+	mu          sync.RWMutex
+	root        *node
+	suggestions []Suggestion
+
+	changes sync.Mutex
+	kittens map[string]data.Kitten
+	refresh *time.Timer
+}
+
+// New creates a Suggester for the given kittens
+func New(kittens []data.Kitten) *Suggester {
+	s := &Suggester{kittens: make(map[string]data.Kitten)}
+	for _, k := range kittens {
+		s.kittens[k.Id] = k
+	}
+
+	s.build(kittens)
+
+	return s
+}
+
+// Load creates a Suggester for every kitten held by source
+func Load(ctx context.Context, source data.Store) (*Suggester, error) {
+	kittens, err := source.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(kittens), nil
+}
+
+// Reload rebuilds the trie from source and atomically replaces the current
+// contents, the current contents are kept when source returns an error
+func (s *Suggester) Reload(ctx context.Context, source data.Store) error {
+	fresh, err := Load(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	s.changes.Lock()
+	s.kittens = fresh.kittens
+	s.changes.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.root = fresh.root
+	s.suggestions = fresh.suggestions
+
+	return nil
+}
+
+// Put adds or replaces a kitten
+func (s *Suggester) Put(k data.Kitten) {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.kittens[k.Id] = k
+	s.scheduleRefresh()
+}
+
+// Remove deletes the kitten with id
+func (s *Suggester) Remove(id string) {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	delete(s.kittens, id)
+	s.scheduleRefresh()
+}
+
+// scheduleRefresh rebuilds the trie after refreshDelay unless a rebuild is
+// already pending, the caller must hold the changes lock
+func (s *Suggester) scheduleRefresh() {
+	if s.refresh == nil {
+		s.refresh = time.AfterFunc(refreshDelay, s.rebuild)
+	}
+}
+
+// rebuild replaces the trie with one built from the current kittens
+func (s *Suggester) rebuild() {
+	s.changes.Lock()
+	kittens := make([]data.Kitten, 0, len(s.kittens))
+	for _, k := range s.kittens {
+		kittens = append(kittens, k)
+	}
+	s.refresh = nil
+	s.changes.Unlock()
+
+	fresh := &Suggester{}
+	fresh.build(kittens)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.root = fresh.root
+	s.suggestions = fresh.suggestions
+}
+
+// Suggest returns up to n names which complete prefix, either from the start
+// of the name or from the start of any word in it, ordered by weight. Nothing
+// is returned when n is not positive.
+func (s *Suggester) Suggest(prefix string, n int) []Suggestion {
+	if n <= 0 {
+		return nil
+	}
+	if n > MaxSuggestions {
+		n = MaxSuggestions
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	current := s.root
+	for _, r := range strings.ToLower(prefix) {
+		current = current.children[r]
+		if current == nil {
+			return nil
+		}
+	}
+
+	best := current.best
+	if len(best) > n {
+		best = best[:n]
+	}
+
+	suggestions := make([]Suggestion, len(best))
+	for i, id := range best {
+		suggestions[i] = s.suggestions[id]
+	}
+
+	return suggestions
+}
+
+func (s *Suggester) build(kittens []data.Kitten) {
+	weights := make(map[string]float64)
+	var names []string
+
+	for _, k := range kittens {
+		if _, ok := weights[k.Name]; !ok {
+			names = append(names, k.Name)
+		}
+		weights[k.Name] += float64(k.Weight)
+	}
+
+	s.root = &node{}
+	s.suggestions = make([]Suggestion, len(names))
+
+	for id, name := range names {
+		s.suggestions[id] = Suggestion{Name: name, Weight: weights[name]}
+
+		for _, key := range keys(name) {
+			s.insert(key, id)
+		}
+	}
+
+	s.rank(s.root)
+}
+
+// insert adds id to every node on the path for key
+func (s *Suggester) insert(key string, id int) {
+	current := s.root
+	for _, r := range key {
+		child, ok := current.children[r]
+		if !ok {
+			if current.children == nil {
+				current.children = make(map[rune]*node)
+			}
+
+			child = &node{}
+			current.children[r] = child
+		}
+
+		child.best = appendUnique(child.best, id)
+		current = child
+	}
+}
+
+// rank orders the ids held at every node by weight keeping the best
+// MaxSuggestions
+func (s *Suggester) rank(n *node) {
+	sort.Slice(n.best, func(a, b int) bool {
+		x, y := s.suggestions[n.best[a]], s.suggestions[n.best[b]]
+		if x.Weight != y.Weight {
+			return x.Weight > y.Weight
+		}
+
+		return x.Name < y.Name
+	})
+
+	if len(n.best) > MaxSuggestions {
+		n.best = n.best[:MaxSuggestions]
+	}
+
+	for _, child := range n.children {
+		s.rank(child)
+	}
+}
+
+// keys returns the lower case name along with every suffix of it which
+// starts at a word so that "fre" completes "Fat Freddy's Cat"
+func keys(name string) []string {
+	name = strings.ToLower(name)
+	keys := []string{name}
+
+	for i, r := range name {
+		if i > 0 && unicode.IsSpace(r) {
+			if rest := strings.TrimLeftFunc(name[i:], unicode.IsSpace); rest != "" {
+				keys = append(keys, rest)
+			}
+		}
+	}
+
+	return keys
+}
+
+func appendUnique(ids []int, id int) []int {
+	if n := len(ids); n > 0 && ids[n-1] == id {
+		return ids
+	}
+
+	return append(ids, id)
+}
@@ -0,0 +1,71 @@
+package suggest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestCompletesPrefixOfName(t *testing.T) {
+	s := load(t)
+
+	suggestions := s.Suggest("gar", 5)
+
+	assert.Equal(t, []Suggestion{{Name: "Garfield", Weight: 35}}, suggestions)
+}
+
+func TestSuggestCompletesPrefixOfAnyWord(t *testing.T) {
+	s := load(t)
+
+	suggestions := s.Suggest("FRED", 5)
+
+	assert.Equal(t, 1, len(suggestions))
+	assert.Equal(t, "Fat Freddy's Cat", suggestions[0].Name)
+}
+
+func TestSuggestRanksByWeightAndLimitsResults(t *testing.T) {
+	s := New([]data.Kitten{
+		{Id: "1", Name: "Felix", Weight: 12},
+		{Id: "2", Name: "Fat Freddy's Cat", Weight: 20},
+		{Id: "3", Name: "Felicity", Weight: 5},
+	})
+
+	suggestions := s.Suggest("f", 2)
+
+	assert.Equal(t, 2, len(suggestions))
+	assert.Equal(t, "Fat Freddy's Cat", suggestions[0].Name)
+	assert.Equal(t, "Felix", suggestions[1].Name)
+}
+
+func TestSuggestReturnsNothingForUnknownPrefix(t *testing.T) {
+	s := load(t)
+
+	assert.Equal(t, 0, len(s.Suggest("tom", 5)))
+}
+
+func TestSuggestReturnsNothingWhenLimitIsNotPositive(t *testing.T) {
+	s := load(t)
+
+	assert.Equal(t, 0, len(s.Suggest("gar", 0)))
+	assert.Equal(t, 0, len(s.Suggest("gar", -1)))
+}
+
+func TestSuggestReflectsChangesAfterRebuild(t *testing.T) {
+	s := load(t)
+
+	s.Put(data.Kitten{Id: "4", Name: "Tom", Weight: 10})
+	s.Remove("3")
+	s.rebuild()
+
+	assert.Equal(t, "Tom", s.Suggest("to", 5)[0].Name)
+	assert.Equal(t, 0, len(s.Suggest("gar", 5)))
+}
+
+func load(t *testing.T) *Suggester {
+	s, err := Load(context.Background(), &data.MemoryStore{})
+	assert.Nil(t, err)
+
+	return s
+}
@@ -0,0 +1,33 @@
+package data
+
+import (
+	"github.com/building-microservices-with-go/chapter10-services-search/data/analysis"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/fuzzy"
+)
+
+// termDictionary holds every term found in a set of kitten names and finds
+// those within an edit distance of a query term
+type termDictionary struct {
+	tree *fuzzy.Tree
+}
+
+func newTermDictionary(names []string) *termDictionary {
+	d := &termDictionary{tree: fuzzy.NewTree()}
+	for _, name := range names {
+		d.add(name)
+	}
+
+	return d
+}
+
+// add adds the terms in name to the dictionary
+func (d *termDictionary) add(name string) {
+	for _, term := range analysis.Tokenize(name) {
+		d.tree.Add(term)
+	}
+}
+
+// lookup returns the terms within distance edits of term
+func (d *termDictionary) lookup(term string, distance int) []string {
+	return d.tree.Search(term, distance)
+}
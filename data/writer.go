@@ -0,0 +1,180 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when a kitten does not exist
+	ErrNotFound = errors.New("kitten not found")
+	// ErrExists is returned when creating a kitten with an id already in use
+	ErrExists = errors.New("kitten already exists")
+	// ErrVersionMismatch is returned when a kitten has been changed since the
+	// version the caller expected
+	ErrVersionMismatch = errors.New("kitten has been modified")
+)
+
+// ErrImportUnsupported is returned when importing into a store which is not
+// an Importer
+var ErrImportUnsupported = errors.New("store does not support imports")
+
+// AnyVersion is passed to Update and Delete to write a kitten regardless of
+// its current version
+const AnyVersion int64 = 0
+
+// Writer is an interface used for managing the kittens in a datastore, a
+// call whose context is done returns ErrTimeout or the context's error
+type Writer interface {
+	// Get returns the kitten with id or ErrNotFound
+	Get(ctx context.Context, id string) (Kitten, error)
+	// Create adds a new kitten at version 1 and returns it, or returns
+	// ErrExists when the id is in use
+	Create(ctx context.Context, k Kitten) (Kitten, error)
+	// Update replaces an existing kitten and returns it with its new version,
+	// it returns ErrNotFound or ErrVersionMismatch when version is not
+	// AnyVersion and does not match the stored kitten
+	Update(ctx context.Context, k Kitten, version int64) (Kitten, error)
+	// Delete removes a kitten or returns ErrNotFound, or ErrVersionMismatch
+	// when version is not AnyVersion and does not match the stored kitten
+	Delete(ctx context.Context, id string, version int64) error
+}
+
+// Validate returns an error describing why k can not be stored
+func (k Kitten) Validate() error {
+	if k.Id == "" {
+		return fmt.Errorf("id must not be empty")
+	}
+
+	if len(k.Id) > 50 {
+		return fmt.Errorf("id must be at most 50 characters")
+	}
+
+	if !validId(k.Id) {
+		return fmt.Errorf("id must only contain letters, digits, '-', '.', '_' and '~' and must not start with '_'")
+	}
+
+	if k.Name == "" {
+		return fmt.Errorf("name must not be empty")
+	}
+
+	if len(k.Name) > 200 {
+		return fmt.Errorf("name must be at most 200 characters")
+	}
+
+	if k.Weight <= 0 {
+		return fmt.Errorf("weight must be greater than zero")
+	}
+
+	return nil
+}
+
+// validId returns true when id can be used unescaped as a path segment, ids
+// starting with _ are reserved for endpoints such as /kittens/_bulk and the
+// dot segments would be removed from the path
+func validId(id string) bool {
+	if strings.HasPrefix(id, "_") || id == "." || id == ".." {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewId returns a random id for a new kitten
+func NewId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Listener is notified after kittens have been written so that secondary
+// stores such as the search index can be kept up to date
+type Listener interface {
+	Put(k Kitten)
+	Remove(id string)
+}
+
+// Observe returns a Writer which writes to w and then notifies listeners of
+// every successful change
+func Observe(w Writer, listeners ...Listener) Writer {
+	return &observedWriter{Writer: w, listeners: listeners}
+}
+
+type observedWriter struct {
+	Writer
+	listeners []Listener
+}
+
+func (o *observedWriter) Create(ctx context.Context, k Kitten) (Kitten, error) {
+	k, err := o.Writer.Create(ctx, k)
+	if err != nil {
+		return k, err
+	}
+
+	for _, l := range o.listeners {
+		l.Put(k)
+	}
+
+	return k, nil
+}
+
+func (o *observedWriter) Update(ctx context.Context, k Kitten, version int64) (Kitten, error) {
+	k, err := o.Writer.Update(ctx, k, version)
+	if err != nil {
+		return k, err
+	}
+
+	for _, l := range o.listeners {
+		l.Put(k)
+	}
+
+	return k, nil
+}
+
+// Import writes kittens when the underlying Writer is an Importer and then
+// notifies listeners of each kitten written
+func (o *observedWriter) Import(ctx context.Context, kittens []Kitten, options ImportOptions) (ImportResult, error) {
+	importer, ok := o.Writer.(Importer)
+	if !ok {
+		return ImportResult{}, ErrImportUnsupported
+	}
+
+	result, err := importer.Import(ctx, kittens, options)
+	if err != nil {
+		return result, err
+	}
+
+	for _, l := range o.listeners {
+		for _, k := range result.Kittens {
+			l.Put(k)
+		}
+	}
+
+	return result, nil
+}
+
+func (o *observedWriter) Delete(ctx context.Context, id string, version int64) error {
+	if err := o.Writer.Delete(ctx, id, version); err != nil {
+		return err
+	}
+
+	for _, l := range o.listeners {
+		l.Remove(id)
+	}
+
+	return nil
+}
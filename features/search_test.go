@@ -2,6 +2,7 @@ package features
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -80,10 +81,12 @@ func startServer() {
 	outb = bytes.Buffer{}
 	errb = bytes.Buffer{}
 
-	server = exec.Command("go", "build", "../main.go")
+	server = exec.Command("go", "build", "-o", "main", "..")
 	server.Run()
 
 	server = exec.Command("./main")
+	// the features search without bearer tokens
+	server.Env = append(os.Environ(), "SEARCH_AUTH_DISABLED=true")
 
 	if os.Getenv("DEBUG") == "true" {
 		server.Stderr = os.Stderr
@@ -116,7 +119,9 @@ func clearDB() {
 }
 
 func setupData() {
-	store.CreateSchema()
+	if err := store.Migrate(context.Background()); err != nil {
+		log.Fatalln("Unable to migrate the schema:", err)
+	}
 
 	err := store.InsertKittens(
 		[]data.Kitten{
@@ -150,9 +155,9 @@ func FeatureContext(s *godog.Suite) {
 	s.Step(`^I should receive a list of kittens$`, iShouldReceiveAListOfKittens)
 
 	s.BeforeScenario(func(interface{}) {
-		startServer()
 		clearDB()
 		setupData()
+		startServer()
 	})
 
 	s.AfterScenario(func(interface{}, error) {
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+)
+
+// adminReloadPath reloads the configuration of the service
+const adminReloadPath = "/admin/reload"
+
+// Reloader reloads the configuration of the service, the current
+// configuration is kept when an error is returned
+type Reloader interface {
+	Reload() error
+}
+
+// Admin is an http handler for operational endpoints, requests must either
+// be authorized by the auth policy or carry the admin token as a bearer
+// token
+type Admin struct {
+	reloader Reloader
+	token    string
+	statsd   *statsd.Client
+}
+
+// Handle routes POST /admin/reload
+func (a *Admin) Handle(rw http.ResponseWriter, r *http.Request) {
+	defer func(startTime time.Time) {
+		a.statsd.Timing("admin.timing.total", time.Now().Sub(startTime), nil, 1)
+	}(time.Now())
+
+	if !a.authorized(r) {
+		a.statsd.Incr("admin.unauthorized", nil, 1)
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path != adminReloadPath:
+		http.NotFound(rw, r)
+	case r.Method != http.MethodPost:
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+	default:
+		a.reload(rw)
+	}
+}
+
+func (a *Admin) reload(rw http.ResponseWriter) {
+	if err := a.reloader.Reload(); err != nil {
+		a.statsd.Incr("admin.reload.failed", nil, 1)
+		http.Error(rw, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	a.statsd.Incr("admin.reload.success", nil, 1)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// authorized returns true when the request was authenticated with a token
+// which the policy allowed to use the admin endpoints, or when it carries
+// the admin token. Requests without claims are refused when no admin token
+// is configured.
+func (a *Admin) authorized(r *http.Request) bool {
+	const prefix = "Bearer "
+
+	if _, ok := auth.FromContext(r.Context()); ok {
+		return true
+	}
+
+	h := r.Header.Get("Authorization")
+	if a.token == "" || !strings.HasPrefix(h, prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(h, prefix)), []byte(a.token)) == 1
+}
+
+func NewAdmin(reloader Reloader, token string, statsd *statsd.Client) *Admin {
+	return &Admin{
+		reloader: reloader,
+		token:    token,
+		statsd:   statsd,
+	}
+}
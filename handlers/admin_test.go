@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeReloader struct {
+	err   error
+	calls int
+}
+
+func (f *fakeReloader) Reload() error {
+	f.calls++
+	return f.err
+}
+
+func TestAdminHandlerReloadsConfiguration(t *testing.T) {
+	reloader := &fakeReloader{}
+	rw := httptest.NewRecorder()
+
+	NewAdmin(reloader, "secret", nil).Handle(rw, adminRequest("POST", "Bearer secret"))
+
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+	assert.Equal(t, 1, reloader.calls)
+}
+
+func TestAdminHandlerRejectsAMissingOrWrongToken(t *testing.T) {
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		reloader := &fakeReloader{}
+		rw := httptest.NewRecorder()
+
+		NewAdmin(reloader, "secret", nil).Handle(rw, adminRequest("POST", auth))
+
+		assert.Equal(t, http.StatusUnauthorized, rw.Code, auth)
+		assert.Equal(t, 0, reloader.calls, auth)
+	}
+}
+
+func TestAdminHandlerIsDisabledWithoutAToken(t *testing.T) {
+	rw := httptest.NewRecorder()
+
+	NewAdmin(&fakeReloader{}, "", nil).Handle(rw, adminRequest("POST", "Bearer "))
+
+	assert.Equal(t, http.StatusUnauthorized, rw.Code)
+}
+
+func TestAdminHandlerReportsAnInvalidConfiguration(t *testing.T) {
+	reloader := &fakeReloader{err: errors.New("invalid configuration:\n  cache.ttl: must be a positive duration")}
+	rw := httptest.NewRecorder()
+
+	NewAdmin(reloader, "secret", nil).Handle(rw, adminRequest("POST", "Bearer secret"))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rw.Code)
+	assert.Contains(t, rw.Body.String(), "cache.ttl")
+}
+
+func adminRequest(method, auth string) *http.Request {
+	r := httptest.NewRequest(method, "/admin/reload", nil)
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+
+	return r
+}
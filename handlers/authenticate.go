@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+)
+
+// TokenVerifier checks a bearer token and returns its claims
+type TokenVerifier interface {
+	Verify(token string) (*auth.Claims, error)
+}
+
+// rejections names the statsd counter incremented for each reason a token is
+// rejected, search.auth.invalid counts any other error
+var rejections = map[error]string{
+	auth.ErrMalformed:   "search.auth.malformed",
+	auth.ErrAlgorithm:   "search.auth.algorithm",
+	auth.ErrUnknownKey:  "search.auth.unknownkey",
+	auth.ErrSignature:   "search.auth.signature",
+	auth.ErrExpired:     "search.auth.expired",
+	auth.ErrNotYetValid: "search.auth.notyetvalid",
+	auth.ErrIssuer:      "search.auth.issuer",
+	auth.ErrAudience:    "search.auth.audience",
+}
+
+// Authenticate is middleware which verifies the bearer token of a request
+// and checks the policy allows the request, the claims of the token are
+// added to the request context. Routes which the policy makes public are
+// passed on without a token.
+type Authenticate struct {
+	verifier TokenVerifier
+	statsd   *statsd.Client
+
+	mu     sync.RWMutex
+	policy *auth.Policy
+}
+
+// forbiddenResponse explains why a request was denied
+type forbiddenResponse struct {
+	Error string `json:"error"`
+	*auth.Denied
+}
+
+// SetPolicy replaces the policy checked by later requests
+func (a *Authenticate) SetPolicy(policy *auth.Policy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.policy = policy
+}
+
+// Wrap returns a handler which authenticates and authorizes requests before
+// calling next
+func (a *Authenticate) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		policy := a.policy
+		a.mu.RUnlock()
+
+		if permission, ok := policy.Required(r.Method, r.URL.Path); ok && permission == auth.PermissionPublic {
+			next(rw, r)
+			return
+		}
+
+		claims, ok := a.authenticate(rw, r)
+		if !ok {
+			return
+		}
+
+		if err := policy.Authorize(claims, r.Method, r.URL.Path); err != nil {
+			a.forbidden(rw, err.(*auth.Denied))
+			return
+		}
+
+		next(rw, r.WithContext(auth.NewContext(r.Context(), claims)))
+	}
+}
+
+// authenticate returns the claims of the request's token, when the token is
+// missing or invalid a 401 response is written and ok is false
+func (a *Authenticate) authenticate(rw http.ResponseWriter, r *http.Request) (claims *auth.Claims, ok bool) {
+	const prefix = "Bearer "
+
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		a.statsd.Incr("search.auth.missing", nil, 1)
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="search"`)
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	claims, err := a.verifier.Verify(strings.TrimSpace(strings.TrimPrefix(h, prefix)))
+	if err != nil {
+		metric, ok := rejections[err]
+		if !ok {
+			metric = "search.auth.invalid"
+		}
+
+		a.statsd.Incr(metric, nil, 1)
+		rw.Header().Set("WWW-Authenticate", `Bearer realm="search", error="invalid_token", error_description="`+err.Error()+`"`)
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	a.statsd.Incr("search.auth.success", nil, 1)
+	return claims, true
+}
+
+func (a *Authenticate) forbidden(rw http.ResponseWriter, denied *auth.Denied) {
+	a.statsd.Incr("search.auth.forbidden", nil, 1)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(forbiddenResponse{Error: "forbidden", Denied: denied})
+}
+
+func NewAuthenticate(verifier TokenVerifier, policy *auth.Policy, statsd *statsd.Client) *Authenticate {
+	return &Authenticate{
+		verifier: verifier,
+		policy:   policy,
+		statsd:   statsd,
+	}
+}
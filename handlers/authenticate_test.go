@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeVerifier struct{}
+
+func (fakeVerifier) Verify(token string) (*auth.Claims, error) {
+	if token == "valid" {
+		return &auth.Claims{Subject: "nic", Scope: auth.PermissionRead}, nil
+	}
+
+	return nil, auth.ErrExpired
+}
+
+func TestAuthenticatePassesClaimsToTheNextHandler(t *testing.T) {
+	var claims *auth.Claims
+	handler := NewAuthenticate(fakeVerifier{}, defaultPolicy(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {
+		claims, _ = auth.FromContext(r.Context())
+	})
+
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Authorization", "Bearer valid")
+	rw := httptest.NewRecorder()
+	handler(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "nic", claims.Subject)
+}
+
+func TestAuthenticateRejectsMissingAndInvalidTokens(t *testing.T) {
+	called := false
+	handler := NewAuthenticate(fakeVerifier{}, defaultPolicy(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	for header, description := range map[string]string{"": "", "Bearer expired": "token has expired"} {
+		r := httptest.NewRequest("POST", "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		rw := httptest.NewRecorder()
+		handler(rw, r)
+
+		assert.Equal(t, http.StatusUnauthorized, rw.Code)
+		assert.Contains(t, rw.Header().Get("WWW-Authenticate"), description)
+	}
+
+	assert.False(t, called)
+}
+
+func TestAuthenticateForbidsTokensWithoutThePermission(t *testing.T) {
+	called := false
+	handler := NewAuthenticate(fakeVerifier{}, defaultPolicy(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	for path, expected := range map[string]string{
+		"/kittens":  `{"error":"forbidden","reason":"missing_permission","required":"kittens:write"}`,
+		"/unlisted": `{"error":"forbidden","reason":"no_policy"}`,
+	} {
+		r := httptest.NewRequest("POST", path, nil)
+		r.Header.Set("Authorization", "Bearer valid")
+		rw := httptest.NewRecorder()
+		handler(rw, r)
+
+		assert.Equal(t, http.StatusForbidden, rw.Code)
+		assert.JSONEq(t, expected, rw.Body.String())
+	}
+
+	assert.False(t, called)
+}
+
+func TestAuthenticatePassesPublicRoutesWithoutAToken(t *testing.T) {
+	called := false
+	handler := NewAuthenticate(fakeVerifier{}, defaultPolicy(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	r := httptest.NewRequest("GET", "/health", nil)
+	rw := httptest.NewRecorder()
+	handler(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.True(t, called)
+}
+
+func defaultPolicy() *auth.Policy {
+	policy, _ := auth.NewPolicy(auth.DefaultRules, nil)
+	return policy
+}
@@ -3,13 +3,24 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/resilient"
 )
 
+// Circuit reports the state of the circuit breaker around a dependency
+type Circuit interface {
+	State() resilient.State
+}
+
+// Health reports that the service is up along with the state of each
+// circuit. An open circuit does not fail the health check as searches are
+// still served from the index, it is reported as DEGRADED.
 type Health struct {
-	statsd *statsd.Client
+	circuits map[string]Circuit
+	statsd   *statsd.Client
 }
 
 func (h *Health) Handle(rw http.ResponseWriter, r *http.Request) {
@@ -17,12 +28,28 @@ func (h *Health) Handle(rw http.ResponseWriter, r *http.Request) {
 		h.statsd.Timing("health.timing", time.Now().Sub(startTime), nil, 1)
 	}(time.Now())
 
+	names := make([]string, 0, len(h.circuits))
+	states := make(map[string]resilient.State, len(h.circuits))
+	status := "OK"
+	for name, c := range h.circuits {
+		names = append(names, name)
+		states[name] = c.State()
+		if states[name] != resilient.StateClosed {
+			status = "DEGRADED"
+		}
+	}
+	sort.Strings(names)
+
 	h.statsd.Incr("health.success", nil, 1)
-	fmt.Fprintln(rw, "OK")
+	fmt.Fprintln(rw, status)
+	for _, name := range names {
+		fmt.Fprintf(rw, "%s circuit: %s\n", name, states[name])
+	}
 }
 
-func NewHealth(statsd *statsd.Client) *Health {
+func NewHealth(circuits map[string]Circuit, statsd *statsd.Client) *Health {
 	return &Health{
-		statsd: statsd,
+		circuits: circuits,
+		statsd:   statsd,
 	}
 }
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data/resilient"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedCircuit resilient.State
+
+func (c fixedCircuit) State() resilient.State {
+	return resilient.State(c)
+}
+
+func TestHealthReportsEachCircuit(t *testing.T) {
+	rw := httptest.NewRecorder()
+	NewHealth(map[string]Circuit{"store": fixedCircuit(resilient.StateClosed)}, nil).Handle(rw, httptest.NewRequest("GET", "/health", nil))
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "OK\nstore circuit: closed\n", rw.Body.String())
+}
+
+func TestHealthIsDegradedButUpWhenACircuitIsOpen(t *testing.T) {
+	rw := httptest.NewRecorder()
+	NewHealth(map[string]Circuit{"store": fixedCircuit(resilient.StateOpen)}, nil).Handle(rw, httptest.NewRequest("GET", "/health", nil))
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "DEGRADED\nstore circuit: open\n", rw.Body.String())
+}
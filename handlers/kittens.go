@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+)
+
+// kittensPath is the path of the kittens resource, individual kittens are
+// addressed as /kittens/{id}
+const kittensPath = "/kittens"
+
+// bulkId is the final path segment of the bulk import endpoint
+const bulkId = "_bulk"
+
+// maxBulkKittens is the largest number of kittens accepted by a single bulk
+// import
+const maxBulkKittens = 10000
+
+// maxBulkBytes is the largest body accepted by a single bulk import, it
+// allows for maxBulkKittens kittens with long names
+const maxBulkBytes = 8 << 20
+
+// bulkResponse reports the outcome of a bulk import, errors are keyed by the
+// line of the request body which they relate to
+type bulkResponse struct {
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Errors  []bulkError `json:"errors"`
+}
+
+type bulkError struct {
+	Line  int    `json:"line"`
+	Id    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// kittenPatch is the body of a PATCH request, only the fields which are set
+// are changed
+type kittenPatch struct {
+	Name   *string  `json:"name"`
+	Weight *float32 `json:"weight"`
+}
+
+// Kittens is an http handler which manages the kittens in the data store
+type Kittens struct {
+	dataStore data.Writer
+	statsd    *statsd.Client
+}
+
+// Handle routes POST /kittens, POST /kittens/_bulk and GET, PUT, PATCH and
+// DELETE /kittens/{id}
+func (k *Kittens) Handle(rw http.ResponseWriter, r *http.Request) {
+	defer func(startTime time.Time) {
+		k.statsd.Timing("kittens.timing.total", time.Now().Sub(startTime), nil, 1)
+	}(time.Now())
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, kittensPath), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		k.create(rw, r)
+	case id == bulkId && r.Method == http.MethodPost:
+		k.bulk(rw, r)
+	case id == bulkId:
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodGet:
+		k.get(rw, r, id)
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodPut:
+		k.update(rw, r, id)
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodPatch:
+		k.patch(rw, r, id)
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodDelete:
+		k.delete(rw, r, id)
+	case id == "":
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+	default:
+		rw.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (k *Kittens) create(rw http.ResponseWriter, r *http.Request) {
+	kitten := data.Kitten{}
+	if !k.decode(rw, r, &kitten) {
+		return
+	}
+
+	if kitten.Id == "" {
+		kitten.Id = data.NewId()
+	}
+
+	if !k.validate(rw, kitten) {
+		return
+	}
+
+	kitten, err := k.dataStore.Create(r.Context(), kitten)
+	if err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	k.statsd.Incr("kittens.created", nil, 1)
+
+	rw.Header().Set("Location", kittensPath+"/"+url.PathEscape(kitten.Id))
+	k.write(rw, http.StatusCreated, kitten)
+}
+
+// bulk imports the kittens in a newline delimited JSON body, one kitten per
+// line, existing kittens are replaced when the upsert parameter is true
+func (k *Kittens) bulk(rw http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	importer, ok := k.dataStore.(data.Importer)
+	if !ok {
+		k.writeError(rw, data.ErrImportUnsupported)
+		return
+	}
+
+	options := data.ImportOptions{}
+	if upsert := r.URL.Query().Get("upsert"); upsert != "" {
+		var err error
+		if options.Upsert, err = strconv.ParseBool(upsert); err != nil {
+			k.badRequest(rw, "upsert must be true or false")
+			return
+		}
+	}
+
+	var kittens []data.Kitten
+	var lines []int
+	response := bulkResponse{Errors: []bulkError{}}
+
+	reader := bufio.NewReader(http.MaxBytesReader(rw, r.Body, maxBulkBytes))
+	read := 0
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		read += len(text)
+		if err != nil && err != io.EOF && read >= maxBulkBytes {
+			k.statsd.Incr("kittens.badrequest", nil, 1)
+			http.Error(rw, fmt.Sprintf("at most %d bytes can be imported at once", maxBulkBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if err != nil && err != io.EOF {
+			k.badRequest(rw, "Bad Request")
+			return
+		}
+
+		if len(bytes.TrimSpace(text)) > 0 {
+			kitten := data.Kitten{}
+			if jsonErr := json.Unmarshal(text, &kitten); jsonErr != nil {
+				response.Errors = append(response.Errors, bulkError{Line: line, Error: "invalid JSON"})
+			} else {
+				if kitten.Id == "" {
+					kitten.Id = data.NewId()
+				}
+
+				kittens = append(kittens, kitten)
+				lines = append(lines, line)
+			}
+
+			if len(kittens) > maxBulkKittens {
+				k.statsd.Incr("kittens.badrequest", nil, 1)
+				http.Error(rw, fmt.Sprintf("at most %d kittens can be imported at once", maxBulkKittens), http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	result, err := importer.Import(r.Context(), kittens, options)
+	if err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	for _, e := range result.Errors {
+		response.Errors = append(response.Errors, bulkError{Line: lines[e.Row], Id: e.Id, Error: e.Error})
+	}
+	sort.Slice(response.Errors, func(a, b int) bool { return response.Errors[a].Line < response.Errors[b].Line })
+
+	response.Created = result.Created
+	response.Updated = result.Updated
+
+	k.statsd.Count("kittens.imported", int64(result.Created+result.Updated), nil, 1)
+	k.statsd.Count("kittens.importerrors", int64(len(response.Errors)), nil, 1)
+
+	rw.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(response)
+}
+
+func (k *Kittens) get(rw http.ResponseWriter, r *http.Request, id string) {
+	kitten, err := k.dataStore.Get(r.Context(), id)
+	if err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	k.write(rw, http.StatusOK, kitten)
+}
+
+func (k *Kittens) update(rw http.ResponseWriter, r *http.Request, id string) {
+	kitten := data.Kitten{}
+	if !k.decode(rw, r, &kitten) {
+		return
+	}
+
+	if kitten.Id != "" && kitten.Id != id {
+		k.badRequest(rw, "id in the body does not match the url")
+		return
+	}
+
+	versions, ok := k.ifMatch(rw, r)
+	if !ok {
+		return
+	}
+
+	version, ok := k.requiredVersion(rw, r, id, versions)
+	if !ok {
+		return
+	}
+
+	kitten.Id = id
+	if !k.validate(rw, kitten) {
+		return
+	}
+
+	kitten, err := k.dataStore.Update(r.Context(), kitten, version)
+	if err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	k.statsd.Incr("kittens.updated", nil, 1)
+	k.write(rw, http.StatusOK, kitten)
+}
+
+func (k *Kittens) patch(rw http.ResponseWriter, r *http.Request, id string) {
+	patch := kittenPatch{}
+	if !k.decode(rw, r, &patch) {
+		return
+	}
+
+	versions, ok := k.ifMatch(rw, r)
+	if !ok {
+		return
+	}
+
+	kitten, err := k.dataStore.Get(r.Context(), id)
+	if err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	conditional := versions != nil
+	if conditional && !containsVersion(versions, kitten.Version) {
+		k.writeError(rw, data.ErrVersionMismatch)
+		return
+	}
+
+	if patch.Name != nil {
+		kitten.Name = *patch.Name
+	}
+	if patch.Weight != nil {
+		kitten.Weight = *patch.Weight
+	}
+
+	if !k.validate(rw, kitten) {
+		return
+	}
+
+	// the patch is applied to the version which was read so that a change
+	// made in the meantime is not overwritten, without If-Match the caller
+	// did not expect a version and is told of the conflict instead
+	kitten, err = k.dataStore.Update(r.Context(), kitten, kitten.Version)
+	if err == data.ErrVersionMismatch && !conditional {
+		k.statsd.Incr("kittens.conflict", nil, 1)
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	k.statsd.Incr("kittens.updated", nil, 1)
+	k.write(rw, http.StatusOK, kitten)
+}
+
+func (k *Kittens) delete(rw http.ResponseWriter, r *http.Request, id string) {
+	versions, ok := k.ifMatch(rw, r)
+	if !ok {
+		return
+	}
+
+	version, ok := k.requiredVersion(rw, r, id, versions)
+	if !ok {
+		return
+	}
+
+	if err := k.dataStore.Delete(r.Context(), id, version); err != nil {
+		k.writeError(rw, err)
+		return
+	}
+
+	k.statsd.Incr("kittens.deleted", nil, 1)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (k *Kittens) decode(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		k.badRequest(rw, "Bad Request")
+		return false
+	}
+
+	return true
+}
+
+// ifMatch returns the versions listed by the If-Match header, nil when the
+// header is missing or lists *. If-Match uses the strong comparison so weak
+// entity tags, and tags which were not returned by this service, never match
+// and are left out of the list.
+func (k *Kittens) ifMatch(rw http.ResponseWriter, r *http.Request) ([]int64, bool) {
+	header := strings.Join(r.Header["If-Match"], ",")
+	if strings.TrimSpace(header) == "" {
+		return nil, true
+	}
+
+	versions := []int64{}
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			break
+		}
+
+		if header[0] == '*' {
+			return nil, true
+		}
+
+		weak := strings.HasPrefix(header, "W/")
+		if weak {
+			header = header[2:]
+		}
+
+		end := 0
+		if header != "" && header[0] == '"' {
+			end = strings.IndexByte(header[1:], '"') + 1
+		}
+
+		if end == 0 {
+			k.badRequest(rw, "If-Match must be * or a list of entity tags")
+			return nil, false
+		}
+
+		tag := header[:end+1]
+		header = header[end+1:]
+
+		version, err := strconv.ParseInt(tag[1:end], 10, 64)
+		if !weak && err == nil && version > 0 && tag == etag(version) {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, true
+}
+
+// requiredVersion returns the version a write to id must be made against for
+// the versions returned by ifMatch, when several versions are allowed the
+// kitten is read to find which one it is
+func (k *Kittens) requiredVersion(rw http.ResponseWriter, r *http.Request, id string, versions []int64) (int64, bool) {
+	switch len(versions) {
+	case 0:
+		if versions == nil {
+			return data.AnyVersion, true
+		}
+
+		k.writeError(rw, data.ErrVersionMismatch)
+		return 0, false
+	case 1:
+		return versions[0], true
+	}
+
+	kitten, err := k.dataStore.Get(r.Context(), id)
+	if err != nil {
+		k.writeError(rw, err)
+		return 0, false
+	}
+
+	if !containsVersion(versions, kitten.Version) {
+		k.writeError(rw, data.ErrVersionMismatch)
+		return 0, false
+	}
+
+	return kitten.Version, true
+}
+
+// containsVersion returns true when version is one of versions
+func containsVersion(versions []int64, version int64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (k *Kittens) validate(rw http.ResponseWriter, kitten data.Kitten) bool {
+	if err := kitten.Validate(); err != nil {
+		k.badRequest(rw, err.Error())
+		return false
+	}
+
+	return true
+}
+
+func (k *Kittens) badRequest(rw http.ResponseWriter, message string) {
+	k.statsd.Incr("kittens.badrequest", nil, 1)
+	http.Error(rw, message, http.StatusBadRequest)
+}
+
+func (k *Kittens) writeError(rw http.ResponseWriter, err error) {
+	switch err {
+	case data.ErrNotFound:
+		k.statsd.Incr("kittens.notfound", nil, 1)
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case data.ErrExists:
+		k.statsd.Incr("kittens.conflict", nil, 1)
+		http.Error(rw, err.Error(), http.StatusConflict)
+	case data.ErrImportUnsupported:
+		k.statsd.Incr("kittens.error", nil, 1)
+		http.Error(rw, err.Error(), http.StatusNotImplemented)
+	case data.ErrTimeout:
+		k.statsd.Incr("kittens.timeout", nil, 1)
+		http.Error(rw, "Gateway Timeout", http.StatusGatewayTimeout)
+	case data.ErrUnavailable:
+		k.statsd.Incr("kittens.unavailable", nil, 1)
+		http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+	case data.ErrVersionMismatch:
+		k.statsd.Incr("kittens.preconditionfailed", nil, 1)
+		http.Error(rw, err.Error(), http.StatusPreconditionFailed)
+	default:
+		k.statsd.Incr("kittens.error", nil, 1)
+
+		log.Println(err)
+		http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (k *Kittens) write(rw http.ResponseWriter, status int, kitten data.Kitten) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("ETag", etag(kitten.Version))
+	rw.WriteHeader(status)
+
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(kitten)
+}
+
+// etag returns the entity tag for a version of a kitten
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func NewKittens(dataStore data.Writer, statsd *statsd.Client) *Kittens {
+	return &Kittens{
+		dataStore: dataStore,
+		statsd:    statsd,
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/stretchr/testify/assert"
+)
+
+var felix = data.Kitten{Id: "1", Name: "Felix", Weight: 12.3, Version: 1}
+
+func TestKittensHandlerCreatesKitten(t *testing.T) {
+	r, rw, handler := setupKittensTest("POST", "/kittens", felix)
+	mockStore.On("Create", felix).Return(felix, nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, "/kittens/1", rw.Header().Get("Location"))
+	assert.Equal(t, `"1"`, rw.Header().Get("ETag"))
+}
+
+func TestKittensHandlerReturnsConflictWhenKittenExists(t *testing.T) {
+	r, rw, handler := setupKittensTest("POST", "/kittens", felix)
+	mockStore.On("Create", felix).Return(data.Kitten{}, data.ErrExists)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusConflict, rw.Code)
+}
+
+func TestKittensHandlerReturnsBadRequestWhenKittenIsInvalid(t *testing.T) {
+	r, rw, handler := setupKittensTest("POST", "/kittens", data.Kitten{Name: "Felix", Weight: -1})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "weight")
+}
+
+func TestKittensHandlerRejectsIdsWhichAreNotPathSegments(t *testing.T) {
+	for _, id := range []string{"a/b", "a b", "_bulk", ".."} {
+		r, rw, handler := setupKittensTest("POST", "/kittens", data.Kitten{Id: id, Name: "Felix", Weight: 12.3})
+
+		handler.Handle(rw, r)
+
+		assert.Equal(t, http.StatusBadRequest, rw.Code, id)
+		assert.Contains(t, rw.Body.String(), "id must only contain", id)
+	}
+}
+
+func TestKittensHandlerGetsKitten(t *testing.T) {
+	r, rw, handler := setupKittensTest("GET", "/kittens/1", nil)
+	mockStore.On("Get", "1").Return(felix, nil)
+
+	handler.Handle(rw, r)
+
+	kitten := data.Kitten{}
+	json.Unmarshal(rw.Body.Bytes(), &kitten)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, felix, kitten)
+	assert.Equal(t, `"1"`, rw.Header().Get("ETag"))
+}
+
+func TestKittensHandlerReturnsNotFoundForMissingKitten(t *testing.T) {
+	r, rw, handler := setupKittensTest("GET", "/kittens/4", nil)
+	mockStore.On("Get", "4").Return(data.Kitten{}, data.ErrNotFound)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestKittensHandlerPatchesOnlyTheSuppliedFields(t *testing.T) {
+	r, rw, handler := setupKittensTest("PATCH", "/kittens/1", map[string]interface{}{"weight": 14})
+	mockStore.On("Get", "1").Return(felix, nil)
+	mockStore.On("Update", data.Kitten{Id: "1", Name: "Felix", Weight: 14, Version: 1}, int64(1)).
+		Return(data.Kitten{Id: "1", Name: "Felix", Weight: 14, Version: 2}, nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, `"2"`, rw.Header().Get("ETag"))
+}
+
+func TestKittensHandlerReturnsPreconditionFailedWhenIfMatchIsStale(t *testing.T) {
+	r, rw, handler := setupKittensTest("PUT", "/kittens/1", felix)
+	r.Header.Set("If-Match", `"1"`)
+	mockStore.On("Update", felix, int64(1)).Return(data.Kitten{}, data.ErrVersionMismatch)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code)
+}
+
+func TestKittensHandlerReturnsConflictWhenPatchRacesAnotherWrite(t *testing.T) {
+	r, rw, handler := setupKittensTest("PATCH", "/kittens/1", map[string]interface{}{"weight": 14})
+	mockStore.On("Get", "1").Return(felix, nil)
+	mockStore.On("Update", data.Kitten{Id: "1", Name: "Felix", Weight: 14, Version: 1}, int64(1)).
+		Return(data.Kitten{}, data.ErrVersionMismatch)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusConflict, rw.Code)
+}
+
+func TestKittensHandlerReturnsBadRequestForMalformedIfMatch(t *testing.T) {
+	for _, header := range []string{"1", `"1`, "W/"} {
+		r, rw, handler := setupKittensTest("DELETE", "/kittens/1", nil)
+		r.Header.Set("If-Match", header)
+
+		handler.Handle(rw, r)
+
+		assert.Equal(t, http.StatusBadRequest, rw.Code, header)
+	}
+}
+
+func TestKittensHandlerNeverMatchesWeakIfMatch(t *testing.T) {
+	r, rw, handler := setupKittensTest("DELETE", "/kittens/1", nil)
+	r.Header.Set("If-Match", `W/"1"`)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code)
+}
+
+func TestKittensHandlerMatchesAnyTagInAnIfMatchList(t *testing.T) {
+	r, rw, handler := setupKittensTest("DELETE", "/kittens/1", nil)
+	r.Header.Set("If-Match", `W/"3", "2", "3"`)
+	mockStore.On("Get", "1").Return(data.Kitten{Id: "1", Name: "Felix", Weight: 12.3, Version: 3}, nil)
+	mockStore.On("Delete", "1", int64(3)).Return(nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+}
+
+func TestKittensHandlerReturnsPreconditionFailedWhenNoTagInTheListMatches(t *testing.T) {
+	r, rw, handler := setupKittensTest("PATCH", "/kittens/1", map[string]interface{}{"weight": 14})
+	r.Header.Set("If-Match", `"2", "3"`)
+	mockStore.On("Get", "1").Return(felix, nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusPreconditionFailed, rw.Code)
+}
+
+func TestKittensHandlerAcceptsAStarInAnIfMatchList(t *testing.T) {
+	r, rw, handler := setupKittensTest("DELETE", "/kittens/1", nil)
+	r.Header.Set("If-Match", `"2", *`)
+	mockStore.On("Delete", "1", data.AnyVersion).Return(nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+}
+
+func TestKittensHandlerReturnsBadRequestWhenPutIdDoesNotMatch(t *testing.T) {
+	r, rw, handler := setupKittensTest("PUT", "/kittens/2", felix)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestKittensHandlerDeletesKitten(t *testing.T) {
+	r, rw, handler := setupKittensTest("DELETE", "/kittens/1", nil)
+	r.Header.Set("If-Match", `"3"`)
+	mockStore.On("Delete", "1", int64(3)).Return(nil)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusNoContent, rw.Code)
+}
+
+func TestKittensHandlerImportsNewlineDelimitedKittens(t *testing.T) {
+	r, rw, handler := setupKittensTest("POST", "/kittens/_bulk?upsert=true", nil)
+	r.Body = ioutil.NopCloser(strings.NewReader(`{"Id":"1","Name":"Felix","Weight":12.3}
+
+not json
+{"Id":"2","Name":"","Weight":20}
+`))
+	mockStore.On("Import", []data.Kitten{{Id: "1", Name: "Felix", Weight: 12.3}, {Id: "2", Weight: 20}}, data.ImportOptions{Upsert: true}).
+		Return(data.ImportResult{Created: 1, Errors: []data.RowError{{Row: 1, Id: "2", Error: "name must not be empty"}}}, nil)
+
+	handler.Handle(rw, r)
+
+	response := bulkResponse{}
+	json.Unmarshal(rw.Body.Bytes(), &response)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, 1, response.Created)
+	assert.Equal(t, []bulkError{
+		{Line: 3, Error: "invalid JSON"},
+		{Line: 4, Id: "2", Error: "name must not be empty"},
+	}, response.Errors)
+}
+
+func TestKittensHandlerRejectsBulkBodiesOverTheLimit(t *testing.T) {
+	r, rw, handler := setupKittensTest("POST", "/kittens/_bulk", nil)
+	r.Body = ioutil.NopCloser(strings.NewReader(strings.Repeat(" ", maxBulkBytes+1)))
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+}
+
+func TestKittensHandlerReturnsMethodNotAllowed(t *testing.T) {
+	r, rw, handler := setupKittensTest("GET", "/kittens", nil)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
+
+func setupKittensTest(method, url string, d interface{}) (*http.Request, *httptest.ResponseRecorder, *Kittens) {
+	mockStore = &data.MockStore{}
+
+	statsdClient, _ := statsd.New("127.0.0.1:8125")
+
+	h := NewKittens(mockStore, statsdClient)
+
+	rw := httptest.NewRecorder()
+
+	if d == nil {
+		return httptest.NewRequest(method, url, nil), rw, h
+	}
+
+	body, _ := json.Marshal(d)
+	return httptest.NewRequest(method, url, bytes.NewReader(body)), rw, h
+}
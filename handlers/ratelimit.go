@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+	"github.com/building-microservices-with-go/chapter10-services-search/ratelimit"
+)
+
+// RateLimit is middleware which limits the rate and the daily and monthly
+// quotas of requests of each client, requests over a limit are refused with
+// 429 Too Many Requests. A client is
+// identified by the subject of its token, then its X-API-Key header when the
+// key is assigned a tier, then its IP address. Other API keys are ignored as
+// a client could otherwise send a new key with every request to get a new
+// bucket.
+type RateLimit struct {
+	backend ratelimit.Backend
+	statsd  *statsd.Client
+
+	mu    sync.RWMutex
+	tiers *ratelimit.Tiers
+}
+
+// SetTiers replaces the tiers used by later requests
+func (l *RateLimit) SetTiers(tiers *ratelimit.Tiers) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tiers = tiers
+}
+
+// Wrap returns a handler which takes a token from the client's bucket before
+// calling next
+func (l *RateLimit) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		l.mu.RLock()
+		tiers := l.tiers
+		l.mu.RUnlock()
+
+		client := clientOf(r, tiers)
+		_, limit := tiers.Limit(client)
+		if limit.Unlimited() {
+			next(rw, r)
+			return
+		}
+
+		result, err := l.backend.Take(client, limit)
+		if err != nil {
+			// a broken backend must not take the service down with it
+			l.statsd.Incr("search.ratelimit.error", nil, 1)
+			log.Println(err)
+			next(rw, r)
+			return
+		}
+
+		if limit.Burst > 0 {
+			rw.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			rw.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		}
+
+		if result.QuotaLimit > 0 {
+			rw.Header().Set("X-Quota-Limit", strconv.Itoa(result.QuotaLimit))
+			rw.Header().Set("X-Quota-Remaining", strconv.Itoa(result.QuotaRemaining))
+			rw.Header().Set("X-Quota-Reset", strconv.Itoa(ceilSeconds(result.QuotaReset)))
+		}
+
+		if !result.Allowed {
+			if result.QuotaExceeded {
+				l.statsd.Incr("search.ratelimit.quota", nil, 1)
+			} else {
+				l.statsd.Incr("search.ratelimit.limited", nil, 1)
+			}
+			rw.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			http.Error(rw, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next(rw, r)
+	}
+}
+
+// clientOf returns the rate limit key of the client which made r, an API key
+// identifies the client only when it is assigned one of tiers
+func clientOf(r *http.Request, tiers *ratelimit.Tiers) string {
+	if claims, ok := auth.FromContext(r.Context()); ok && claims.Subject != "" {
+		return ratelimit.SubjectClient(claims.Subject)
+	}
+
+	if key := r.Header.Get("X-API-Key"); key != "" && tiers.Assigned(ratelimit.APIKeyClient(key)) {
+		return ratelimit.APIKeyClient(key)
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return ratelimit.IPClient(host)
+}
+
+// ceilSeconds rounds d up to whole seconds as required by Retry-After
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func NewRateLimit(backend ratelimit.Backend, tiers *ratelimit.Tiers, statsd *statsd.Client) *RateLimit {
+	return &RateLimit{
+		backend: backend,
+		tiers:   tiers,
+		statsd:  statsd,
+	}
+}
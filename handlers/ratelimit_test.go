@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+	"github.com/building-microservices-with-go/chapter10-services-search/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingBackend struct{}
+
+func (failingBackend) Take(key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("backend is down")
+}
+
+func TestRateLimitRefusesClientsOverTheirLimit(t *testing.T) {
+	handler := NewRateLimit(ratelimit.NewMemory(), tiers(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {})
+
+	rw := httptest.NewRecorder()
+	handler(rw, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "1", rw.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", rw.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "1", rw.Header().Get("X-RateLimit-Reset"))
+
+	rw = httptest.NewRecorder()
+	handler(rw, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+	assert.Equal(t, "1", rw.Header().Get("Retry-After"))
+}
+
+func TestRateLimitKeysClientsBySubjectThenAPIKeyThenIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	assert.Equal(t, "ip:192.0.2.1", clientOf(r, tiers()))
+
+	r.Header.Set("X-API-Key", "abc")
+	assert.Equal(t, "ip:192.0.2.1", clientOf(r, tiers()))
+
+	r.Header.Set("X-API-Key", "partner")
+	assert.Equal(t, "key:partner", clientOf(r, tiers()))
+
+	r = r.WithContext(auth.NewContext(r.Context(), &auth.Claims{Subject: "nic"}))
+	assert.Equal(t, "subject:nic", clientOf(r, tiers()))
+}
+
+func TestRateLimitIsNotBypassedByRotatingAPIKeys(t *testing.T) {
+	handler := NewRateLimit(ratelimit.NewMemory(), tiers(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {})
+
+	codes := []int{}
+	for _, key := range []string{"first", "second"} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-API-Key", key)
+		rw := httptest.NewRecorder()
+		handler(rw, r)
+
+		codes = append(codes, rw.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests}, codes)
+}
+
+func TestRateLimitUsesTheClientsTier(t *testing.T) {
+	handler := NewRateLimit(ratelimit.NewMemory(), tiers(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {})
+
+	for i := 0; i < 5; i++ {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-API-Key", "partner")
+		rw := httptest.NewRecorder()
+		handler(rw, r)
+
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, "", rw.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitRefusesClientsOverTheirQuota(t *testing.T) {
+	tiers := ratelimit.NewTiers(map[string]ratelimit.Limit{ratelimit.DefaultTier: {Daily: 1}}, nil)
+	handler := NewRateLimit(ratelimit.NewMemory(), tiers, nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {})
+
+	rw := httptest.NewRecorder()
+	handler(rw, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "1", rw.Header().Get("X-Quota-Limit"))
+	assert.Equal(t, "0", rw.Header().Get("X-Quota-Remaining"))
+	assert.NotEqual(t, "", rw.Header().Get("X-Quota-Reset"))
+	assert.Equal(t, "", rw.Header().Get("X-RateLimit-Limit"))
+
+	rw = httptest.NewRecorder()
+	handler(rw, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+	assert.Equal(t, rw.Header().Get("X-Quota-Reset"), rw.Header().Get("Retry-After"))
+}
+
+func TestRateLimitAllowsRequestsWhenTheBackendFails(t *testing.T) {
+	called := false
+	handler := NewRateLimit(failingBackend{}, tiers(), nil).Wrap(func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	assert.True(t, called)
+}
+
+// tiers allows one request then one a second by default, the partner API key
+// is not limited
+func tiers() *ratelimit.Tiers {
+	return ratelimit.NewTiers(
+		map[string]ratelimit.Limit{
+			ratelimit.DefaultTier: {Burst: 1, Refill: 1},
+			"partner":             {},
+		},
+		map[string]string{ratelimit.APIKeyClient("partner"): "partner"},
+	)
+}
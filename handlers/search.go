@@ -2,26 +2,151 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
 )
 
 type searchRequest struct {
-	// Query is the text search query that will be executed by the handler
+	// Query is the text search query that will be executed by the handler,
+	// it supports AND, OR, NOT, "quoted phrases", field scoped terms such as
+	// name:felix and ranges such as weight:[10 TO 25]
 	Query string `json:"query"`
+	// Fuzziness is the number of typos tolerated in each query term, either
+	// a number between 0 and 2 or "auto" to scale with the term length
+	Fuzziness data.Fuzziness `json:"fuzziness"`
+	// Limit is the number of kittens to return, up to 100, default 10
+	Limit int `json:"limit"`
+	// Offset is the number of kittens to skip
+	Offset int `json:"offset"`
+	// Cursor is the next_cursor from a previous response, it returns the
+	// page following that response and can not be combined with offset
+	Cursor string `json:"cursor"`
+	// Sort orders the results by one or more fields, by default results are
+	// ordered by descending relevance
+	Sort []sortRequest `json:"sort"`
+	// Aggregations are summaries computed over every matching kitten such as
+	// weight histograms, the most common names or weight statistics
+	Aggregations []data.Aggregation `json:"aggregations"`
+}
+
+type sortRequest struct {
+	// Field is one of relevance, name, weight or id
+	Field string `json:"field"`
+	// Order is asc or desc, relevance defaults to desc and other fields to asc
+	Order string `json:"order"`
+}
+
+// query validates the request and converts it into a query for the data store
+func (r *searchRequest) query() (data.Query, error) {
+	q := data.Query{
+		Text:      r.Query,
+		Fuzziness: r.Fuzziness,
+		Limit:     r.Limit,
+		Offset:    r.Offset,
+	}
+
+	if q.Limit == 0 {
+		q.Limit = data.DefaultLimit
+	}
+
+	if q.Limit < 0 || q.Limit > data.MaxLimit {
+		return q, fmt.Errorf("limit must be between 1 and %d", data.MaxLimit)
+	}
+
+	if q.Offset < 0 {
+		return q, fmt.Errorf("offset must not be negative")
+	}
+
+	if r.Cursor != "" {
+		if q.Offset > 0 {
+			return q, fmt.Errorf("cursor and offset can not be used together")
+		}
+
+		after, err := data.DecodeCursor(r.Cursor)
+		if err != nil {
+			return q, err
+		}
+
+		q.After = after
+	}
+
+	for _, sort := range r.Sort {
+		if !data.ValidSortField(sort.Field) {
+			return q, fmt.Errorf("unknown sort field %q, expected relevance, name, weight or id", sort.Field)
+		}
+
+		field := data.SortField{Field: sort.Field, Descending: sort.Field == data.SortRelevance}
+		switch sort.Order {
+		case "":
+		case "asc":
+			field.Descending = false
+		case "desc":
+			field.Descending = true
+		default:
+			return q, fmt.Errorf("unknown sort order %q for %s, expected asc or desc", sort.Order, sort.Field)
+		}
+
+		q.Sort = append(q.Sort, field)
+	}
+
+	names := make(map[string]bool)
+	for _, a := range r.Aggregations {
+		if err := a.Validate(); err != nil {
+			return q, err
+		}
+
+		if names[a.Name] {
+			return q, fmt.Errorf("aggregation %s is defined more than once", a.Name)
+		}
+
+		names[a.Name] = true
+	}
+
+	q.Aggregations = r.Aggregations
+
+	expr, err := query.Parse(r.Query)
+	if err != nil {
+		return q, err
+	}
+
+	q.Expr = expr
+	return q, nil
 }
 
 type searchResponse struct {
-	Kittens []data.Kitten `json:"kittens"`
+	// Kittens are the matching kittens ordered by relevance, each with its score
+	Kittens []data.Hit `json:"kittens"`
+	// Total is the number of matching kittens across every page
+	Total int `json:"total"`
+	// NextCursor returns the following page when sent as the cursor of the
+	// next request, it is omitted on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Aggregations holds the result of each requested aggregation by name
+	Aggregations map[string]data.AggregationResult `json:"aggregations,omitempty"`
+	// Stale is true when the store is unavailable and the response is the
+	// last good result of the same search
+	Stale bool `json:"stale,omitempty"`
+}
+
+// Snapshot holds the last good result of recent searches and when each was
+// fetched from the store, it is filled by the data store
+type Snapshot interface {
+	Recall(q data.Query) (r data.Result, stored time.Time, ok bool)
 }
 
-// Search is an http handler for our microservice
+// Search is an http handler for our microservice, when the data store is
+// unavailable or times out searches are answered from the snapshot if it
+// holds them
 type Search struct {
 	dataStore data.Store
+	snapshot  Snapshot
 	statsd    *statsd.Client
 }
 
@@ -35,7 +160,7 @@ func (s *Search) Handle(rw http.ResponseWriter, r *http.Request) {
 
 	request := &searchRequest{}
 	err := decoder.Decode(request)
-	if err != nil || len(request.Query) < 1 {
+	if err != nil || len(request.Query) < 1 || !request.Fuzziness.Valid() {
 		s.statsd.Incr("search.badrequest", nil, 1)
 
 		log.Println(err)
@@ -43,19 +168,86 @@ func (s *Search) Handle(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	q, err := request.query()
+	if err != nil {
+		s.statsd.Incr("search.badrequest", nil, 1)
+
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	startTime := time.Now()
-	kittens := s.dataStore.Search(request.Query)
+	result, err := s.dataStore.Search(r.Context(), q)
 	s.statsd.Timing("search.timing.data", time.Now().Sub(startTime), nil, 1)
 
+	stale := false
+	switch {
+	case (err == data.ErrUnavailable || err == data.ErrTimeout) && s.snapshot != nil:
+		var stored time.Time
+		if result, stored, stale = s.snapshot.Recall(q); !stale {
+			s.statsd.Incr("search.degraded.miss", nil, 1)
+			s.writeError(rw, err)
+			return
+		}
+
+		s.statsd.Incr("search.degraded", nil, 1)
+		rw.Header().Set("X-Search-Degraded", "stale")
+		rw.Header().Set("Age", strconv.Itoa(int(time.Since(stored).Seconds())))
+	case err != nil:
+		s.writeError(rw, err)
+		return
+	}
+
+	response := searchResponse{
+		Kittens:      result.Hits,
+		Total:        result.Total,
+		Aggregations: result.Aggregations,
+		Stale:        stale,
+	}
+	if response.Kittens == nil {
+		response.Kittens = []data.Hit{}
+	}
+
+	if result.Next != nil {
+		response.NextCursor = result.Next.Encode()
+	}
+
 	encoder := json.NewEncoder(rw)
-	encoder.Encode(searchResponse{Kittens: kittens})
+	encoder.Encode(response)
 
 	s.statsd.Incr("search.success", nil, 1)
 }
 
-func NewSearch(dataStore data.Store, statsd *statsd.Client) *Search {
+// writeError maps an error returned by the data store to a response
+func (s *Search) writeError(rw http.ResponseWriter, err error) {
+	if _, ok := err.(*data.InvalidQueryError); ok {
+		s.statsd.Incr("search.badrequest", nil, 1)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err {
+	case data.ErrNotFound:
+		s.statsd.Incr("search.notfound", nil, 1)
+		http.Error(rw, err.Error(), http.StatusNotFound)
+	case data.ErrTimeout:
+		s.statsd.Incr("search.timeout", nil, 1)
+		http.Error(rw, "Gateway Timeout", http.StatusGatewayTimeout)
+	case data.ErrUnavailable:
+		s.statsd.Incr("search.unavailable", nil, 1)
+		http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+	default:
+		s.statsd.Incr("search.error", nil, 1)
+
+		log.Println(err)
+		http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func NewSearch(dataStore data.Store, snapshot Snapshot, statsd *statsd.Client) *Search {
 	return &Search{
 		dataStore: dataStore,
+		snapshot:  snapshot,
 		statsd:    statsd,
 	}
 }
@@ -11,14 +11,17 @@ import (
 
 func BenchmarkSearchHandler(b *testing.B) {
 	mockStore = &data.MockStore{}
-	mockStore.On("Search", "Fat Freddy's Cat").Return([]data.Kitten{
-		data.Kitten{
-			Name: "Fat Freddy's Cat",
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{
+		Hits: []data.Hit{
+			data.Hit{
+				Kitten: data.Kitten{Name: "Fat Freddy's Cat"},
+			},
 		},
-	})
+		Total: 1,
+	}, nil)
 
 	statsdClient, _ := statsd.New("127.0.0.1:8125")
-	search := NewSearch(mockStore, statsdClient)
+	search := NewSearch(mockStore, nil, statsdClient)
 
 	b.ResetTimer()
 
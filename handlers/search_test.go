@@ -3,12 +3,15 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/cache"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/query"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,7 +39,7 @@ func TestSearchHandlerReturnsBadRequestWhenBlankSearchCriteriaIsSent(t *testing.
 
 func TestSearchHandlerCallsDataStoreWithValidQuery(t *testing.T) {
 	r, rw, handler := setupTest(&searchRequest{Query: "Fat Freddy's Cat"})
-	mockStore.On("Search", "Fat Freddy's Cat").Return(make([]data.Kitten, 0))
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{}, nil)
 
 	handler.Handle(rw, r)
 
@@ -45,7 +48,7 @@ func TestSearchHandlerCallsDataStoreWithValidQuery(t *testing.T) {
 
 func TestSearchHandlerReturnsKittensWithValidQuery(t *testing.T) {
 	r, rw, handler := setupTest(&searchRequest{Query: "Fat Freddy's Cat"})
-	mockStore.On("Search", "Fat Freddy's Cat").Return(make([]data.Kitten, 1))
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{Hits: make([]data.Hit, 1), Total: 1}, nil)
 
 	handler.Handle(rw, r)
 
@@ -53,15 +56,214 @@ func TestSearchHandlerReturnsKittensWithValidQuery(t *testing.T) {
 	json.Unmarshal(rw.Body.Bytes(), &response)
 
 	assert.Equal(t, 1, len(response.Kittens))
+	assert.Equal(t, 1, response.Total)
 	assert.Equal(t, http.StatusOK, rw.Code)
 }
 
+func TestSearchHandlerReturnsBadRequestWhenFuzzinessIsOutOfRange(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Garfeild", Fuzziness: 3})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSearchHandlerPassesFuzzinessToDataStore(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Garfeild", Fuzziness: data.FuzzinessAuto})
+	q := expectedQuery("Garfeild")
+	q.Fuzziness = data.FuzzinessAuto
+	mockStore.On("Search", q).Return(data.Result{Hits: make([]data.Hit, 1), Total: 1}, nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestSearchHandlerReturnsBadRequestWhenQueryIsInvalid(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "(felix OR"})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "invalid query")
+}
+
+func TestSearchHandlerReturnsBadRequestWhenLimitIsTooLarge(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Limit: 1000})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSearchHandlerReturnsBadRequestWhenCursorIsCombinedWithOffset(t *testing.T) {
+	cursor := data.CursorFor(data.Hit{Kitten: data.Kitten{Id: "1"}}).Encode()
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Offset: 1, Cursor: cursor})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSearchHandlerReturnsBadRequestWhenCursorIsInvalid(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Cursor: "not a cursor"})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSearchHandlerPassesCursorAndReturnsNextCursor(t *testing.T) {
+	after := &data.Cursor{Score: 1.5, Id: "1"}
+	next := &data.Cursor{Score: 0.5, Id: "3"}
+
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Limit: 1, Cursor: after.Encode()})
+
+	q := expectedQuery("Felix")
+	q.Limit = 1
+	q.After = after
+	mockStore.On("Search", q).Return(data.Result{Hits: make([]data.Hit, 1), Total: 3, Next: next}, nil)
+
+	handler.Handle(rw, r)
+
+	response := searchResponse{}
+	json.Unmarshal(rw.Body.Bytes(), &response)
+
+	mockStore.AssertExpectations(t)
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, next.Encode(), response.NextCursor)
+}
+
+func TestSearchHandlerReturnsBadRequestWhenSortFieldIsUnknown(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Sort: []sortRequest{{Field: "colour"}}})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "unknown sort field")
+}
+
+func TestSearchHandlerPassesSortFieldsToDataStore(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Sort: []sortRequest{{Field: "weight", Order: "desc"}, {Field: "name"}}})
+
+	q := expectedQuery("Felix")
+	q.Sort = []data.SortField{{Field: data.SortWeight, Descending: true}, {Field: data.SortName}}
+	mockStore.On("Search", q).Return(data.Result{}, nil)
+
+	handler.Handle(rw, r)
+
+	mockStore.AssertExpectations(t)
+}
+
+func TestSearchHandlerReturnsBadRequestWhenAggregationIsInvalid(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Aggregations: []data.Aggregation{{Name: "weights", Type: data.AggregationHistogram, Field: "weight"}}})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "interval")
+}
+
+func TestSearchHandlerReturnsAggregations(t *testing.T) {
+	stats := data.Aggregation{Name: "weight", Type: data.AggregationStats, Field: "weight"}
+	r, rw, handler := setupTest(&searchRequest{Query: "Felix", Aggregations: []data.Aggregation{stats}})
+
+	q := expectedQuery("Felix")
+	q.Aggregations = []data.Aggregation{stats}
+	mockStore.On("Search", q).Return(data.Result{
+		Aggregations: map[string]data.AggregationResult{"weight": {Stats: &data.Stats{Count: 1, Min: 12, Max: 12, Avg: 12, Sum: 12}}},
+	}, nil)
+
+	handler.Handle(rw, r)
+
+	response := searchResponse{}
+	json.Unmarshal(rw.Body.Bytes(), &response)
+
+	assert.Equal(t, 12.0, response.Aggregations["weight"].Stats.Avg)
+}
+
+func TestSearchHandlerReturnsServiceUnavailableWhenDataStoreIsUnavailable(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Fat Freddy's Cat"})
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{}, data.ErrUnavailable)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
+
+func TestSearchHandlerServesTheSnapshotWhenDataStoreIsUnavailable(t *testing.T) {
+	_, _, handler := setupTest(nil)
+	result := data.Result{
+		Hits:  []data.Hit{{Kitten: data.Kitten{Name: "Fat Freddy's Cat"}}},
+		Total: 1,
+	}
+	snapshot := cache.NewSnapshot(10, nil)
+	snapshot.Remember(expectedQuery("Fat Freddy's Cat"), result)
+	handler.snapshot = snapshot
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(result, nil).Once()
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{}, data.ErrUnavailable).Once()
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{}, data.ErrTimeout).Once()
+
+	for _, stale := range []bool{false, true, true} {
+		body, _ := json.Marshal(&searchRequest{Query: "Fat Freddy's Cat"})
+		rw := httptest.NewRecorder()
+		handler.Handle(rw, httptest.NewRequest("POST", "/search", bytes.NewReader(body)))
+
+		response := searchResponse{}
+		json.Unmarshal(rw.Body.Bytes(), &response)
+
+		assert.Equal(t, http.StatusOK, rw.Code)
+		assert.Equal(t, stale, response.Stale)
+		assert.Equal(t, 1, response.Total)
+		assert.Equal(t, stale, rw.Header().Get("X-Search-Degraded") == "stale")
+	}
+}
+
+func TestSearchHandlerReturnsServiceUnavailableWhenTheSnapshotMisses(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Fat Freddy's Cat"})
+	handler.snapshot = cache.NewSnapshot(10, nil)
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{}, data.ErrUnavailable)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	assert.Equal(t, "", rw.Header().Get("X-Search-Degraded"))
+}
+
+func TestSearchHandlerReturnsGatewayTimeoutWhenDataStoreTimesOut(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Fat Freddy's Cat"})
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).Return(data.Result{}, data.ErrTimeout)
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rw.Code)
+}
+
+func TestSearchHandlerReturnsBadRequestWhenDataStoreRejectsQuery(t *testing.T) {
+	r, rw, handler := setupTest(&searchRequest{Query: "Fat Freddy's Cat"})
+	mockStore.On("Search", expectedQuery("Fat Freddy's Cat")).
+		Return(data.Result{}, &data.InvalidQueryError{Err: errors.New("too many terms")})
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	assert.Contains(t, rw.Body.String(), "too many terms")
+}
+
+func expectedQuery(text string) data.Query {
+	return data.Query{
+		Text:  text,
+		Expr:  query.MustParse(text),
+		Limit: data.DefaultLimit,
+	}
+}
+
 func setupTest(d interface{}) (*http.Request, *httptest.ResponseRecorder, *Search) {
 	mockStore = &data.MockStore{}
 
 	statsdClient, _ := statsd.New("127.0.0.1:8125")
 
-	h := NewSearch(mockStore, statsdClient)
+	h := NewSearch(mockStore, nil, statsdClient)
 
 	rw := httptest.NewRecorder()
 
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/suggest"
+)
+
+// defaultSuggestions is the number of suggestions returned when the request
+// does not specify a size
+const defaultSuggestions = 5
+
+type suggestResponse struct {
+	Suggestions []suggest.Suggestion `json:"suggestions"`
+}
+
+// Suggest is an http handler which completes kitten names for type-ahead
+type Suggest struct {
+	suggester *suggest.Suggester
+	statsd    *statsd.Client
+}
+
+// Handle returns the best names completing the prefix query parameter, the
+// optional size parameter limits the number of suggestions
+func (s *Suggest) Handle(rw http.ResponseWriter, r *http.Request) {
+	defer func(startTime time.Time) {
+		s.statsd.Timing("suggest.timing.total", time.Now().Sub(startTime), nil, 1)
+	}(time.Now())
+
+	prefix := r.URL.Query().Get("prefix")
+	size := defaultSuggestions
+
+	var err error
+	if v := r.URL.Query().Get("size"); v != "" {
+		size, err = strconv.Atoi(v)
+	}
+
+	if err != nil || len(prefix) < 1 || size < 1 || size > suggest.MaxSuggestions {
+		s.statsd.Incr("suggest.badrequest", nil, 1)
+		http.Error(rw, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	suggestions := s.suggester.Suggest(prefix, size)
+	if suggestions == nil {
+		suggestions = []suggest.Suggestion{}
+	}
+
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(suggestResponse{Suggestions: suggestions})
+
+	s.statsd.Incr("suggest.success", nil, 1)
+}
+
+func NewSuggest(suggester *suggest.Suggester, statsd *statsd.Client) *Suggest {
+	return &Suggest{
+		suggester: suggester,
+		statsd:    statsd,
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/suggest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestHandlerReturnsBadRequestWhenNoPrefixIsSent(t *testing.T) {
+	r, rw, handler := setupSuggestTest("/suggest")
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSuggestHandlerReturnsBadRequestWhenSizeIsTooLarge(t *testing.T) {
+	r, rw, handler := setupSuggestTest("/suggest?prefix=f&size=100")
+
+	handler.Handle(rw, r)
+
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestSuggestHandlerReturnsCompletions(t *testing.T) {
+	r, rw, handler := setupSuggestTest("/suggest?prefix=f&size=1")
+
+	handler.Handle(rw, r)
+
+	response := suggestResponse{}
+	json.Unmarshal(rw.Body.Bytes(), &response)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, 1, len(response.Suggestions))
+	assert.Equal(t, "Fat Freddy's Cat", response.Suggestions[0].Name)
+}
+
+func setupSuggestTest(url string) (*http.Request, *httptest.ResponseRecorder, *Suggest) {
+	statsdClient, _ := statsd.New("127.0.0.1:8125")
+
+	suggester, _ := suggest.Load(context.Background(), &data.MemoryStore{})
+	h := NewSuggest(suggester, statsdClient)
+
+	return httptest.NewRequest("GET", url, nil), httptest.NewRecorder(), h
+}
@@ -1,42 +1,298 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/auth"
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
 	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/cache"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/index"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/suggest"
 	"github.com/building-microservices-with-go/chapter10-services-search/handlers"
+	"github.com/building-microservices-with-go/chapter10-services-search/ratelimit"
 	log "github.com/sirupsen/logrus"
 )
 
-const address = ":8082"
+const usage = `usage: search [flags] [command]
+
+commands:
+  migrate  manage the MySQL schema, run search migrate -h for details
+  config   print the effective configuration with secrets redacted
+
+without a command the search service is started, run search -h for the flags`
 
 func main() {
-	var logger = &log.Logger{
-		Out:       os.Stdout,
-		Formatter: new(log.TextFormatter),
-		Level:     log.DebugLevel,
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
 	}
-
-	store, err := data.NewMySQLStore(os.Getenv("MYSQL_CONNECTION"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	statsdClient, err := statsd.New("127.0.0.1:8125")
+	// the standard logger is used throughout so that the log level can be
+	// changed when the configuration is reloaded
+	log.SetOutput(os.Stdout)
+	level, _ := log.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
+	logger := log.StandardLogger()
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			store, err := data.NewMySQLStore(cfg.Store.MySQLConnection)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			migrate(store.Migrator(), args[1:])
+		case "config":
+			fmt.Println(cfg)
+		default:
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+
+		return
+	}
+
+	logger.WithField("service", "search").Infof("Configuration:\n%s", cfg)
+
+	statsdClient, err := statsd.New(cfg.Statsd.Address)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// prefix every metric with the app name
-	statsdClient.Namespace = "chapter10.search."
+	statsdClient.Namespace = cfg.Statsd.Namespace
+
+	store, err := openStore(cfg.Store, statsdClient)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	search := handlers.NewSearch(store, statsdClient)
-	health := handlers.NewHealth(statsdClient)
+	// searches and suggestions are served from in memory structures which
+	// are bulk loaded from the store and periodically rebuilt
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Search.LoadTimeout))
+	searchIndex, err := index.Load(ctx, store)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	http.DefaultServeMux.HandleFunc("/", search.Handle)
+	suggester, err := suggest.Load(ctx, store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cancel()
+
+	searchIndex.SetMaxAge(maxIndexAge(cfg.Search))
+	search, results, snapshot := newSearch(searchIndex, cfg.Cache, statsdClient)
+
+	// requests must carry a bearer token from the auth service which the
+	// policy allows to use the route unless auth is disabled
+	var authenticator *handlers.Authenticate
+	if cfg.Auth.Enabled() {
+		keys, err := auth.LoadKeys(cfg.Auth.PublicKey, cfg.Auth.JWKS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go refreshKeys(keys, time.Duration(cfg.Auth.JWKSRefresh))
+
+		policy, err := auth.NewPolicy(cfg.Auth.Policy, cfg.Auth.Roles)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		verifier := auth.NewVerifier(keys, cfg.Auth.Issuer, cfg.Auth.Audience, time.Duration(cfg.Auth.Leeway))
+		authenticator = handlers.NewAuthenticate(verifier, policy, statsdClient)
+	} else {
+		logger.WithField("service", "search").Warn("Auth is disabled, requests are not authenticated")
+	}
+
+	// each client may make a burst of requests and then a sustained rate set
+	// by its tier, up to its tier's daily and monthly quotas, the buckets and
+	// quota usage are held in process
+	limiter := handlers.NewRateLimit(ratelimit.NewMemory(), rateLimitTiers(cfg.RateLimit), statsdClient)
+
+	// the log level, ranking, cache, auth policy and rate limits can be
+	// changed without a restart by sending SIGHUP or calling the admin
+	// endpoint
+	reloads, err := newReloader(cfg, os.Args[1:], os.Getenv, statsdClient,
+		func(c config.Config) error {
+			level, err := log.ParseLevel(c.LogLevel)
+			if err == nil {
+				log.SetLevel(level)
+			}
+			return err
+		},
+		func(c config.Config) error {
+			ranking := index.DefaultRanking()
+			// field boosts can be tuned without a release, e.g. SEARCH_BOOSTS="name:3,id:0.5"
+			if c.Search.Boosts != "" {
+				boosts, err := index.ParseBoosts(c.Search.Boosts)
+				if err != nil {
+					return err
+				}
+
+				ranking.Boosts = boosts
+			}
+
+			searchIndex.SetRanking(ranking)
+			results.Invalidate()
+			return nil
+		},
+		func(c config.Config) error {
+			results.SetOptions(cacheOptions(c.Cache))
+			snapshot.SetMaxEntries(c.Cache.SnapshotEntries)
+			return nil
+		},
+		func(c config.Config) error {
+			if authenticator == nil {
+				return nil
+			}
+
+			policy, err := auth.NewPolicy(c.Auth.Policy, c.Auth.Roles)
+			if err == nil {
+				authenticator.SetPolicy(policy)
+			}
+			return err
+		},
+		func(c config.Config) error {
+			limiter.SetTiers(rateLimitTiers(c.RateLimit))
+			return nil
+		},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reloads.reloadOnHangup()
+
+	stopReloads := make(chan struct{})
+	reloadsStopped := make(chan struct{})
+	go func() {
+		defer close(reloadsStopped)
+
+		for {
+			// the interval and timeout are read on every pass so that they
+			// follow configuration reloads
+			settings := reloads.Config().Search
+			select {
+			case <-time.After(time.Duration(settings.ReloadInterval)):
+			case <-stopReloads:
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.LoadTimeout))
+			reloadSearch(ctx, store, searchIndex, results, settings)
+
+			if err := suggester.Reload(ctx, store); err != nil {
+				log.WithError(err).Error("Unable to reload suggestions")
+			}
+			cancel()
+		}
+	}()
+
+	suggestions := handlers.NewSuggest(suggester, statsdClient)
+	// writes go to the store and are then applied to the index and
+	// suggestions before the cached results are discarded
+	kittens := handlers.NewKittens(data.Observe(store, searchIndex, suggester, results), statsdClient)
+	// the state of the mysql circuit breaker is reported by the health check
+	circuits := make(map[string]handlers.Circuit)
+	if circuit, ok := store.(handlers.Circuit); ok {
+		circuits["store"] = circuit
+	}
+	health := handlers.NewHealth(circuits, statsdClient)
+	admin := handlers.NewAdmin(reloads, cfg.Admin.Token, statsdClient)
+
+	http.DefaultServeMux.HandleFunc("/", limiter.Wrap(search.Handle))
+	http.DefaultServeMux.HandleFunc("/suggest", limiter.Wrap(suggestions.Handle))
+	http.DefaultServeMux.HandleFunc("/kittens", limiter.Wrap(kittens.Handle))
+	http.DefaultServeMux.HandleFunc("/kittens/", limiter.Wrap(kittens.Handle))
 	http.DefaultServeMux.HandleFunc("/health", health.Handle)
+	http.DefaultServeMux.HandleFunc("/admin/", admin.Handle)
+
+	// every route is checked against the policy, so routes added later are
+	// denied until the policy allows them
+	var handler http.Handler = http.DefaultServeMux
+	if authenticator != nil {
+		handler = authenticator.Wrap(http.DefaultServeMux.ServeHTTP)
+	}
+
+	logger.WithField("service", "search").Infof("Starting server, listening on %s", cfg.Address)
+	serveErr := serve(newServer(cfg.Address, handler, cfg.Server), time.Duration(cfg.Server.ShutdownTimeout))
+	if serveErr != nil {
+		log.WithField("service", "search").WithError(serveErr).Error("Server did not shut down cleanly")
+	}
+
+	// nothing uses the store once the requests have drained and the reloads
+	// have stopped
+	close(stopReloads)
+	<-reloadsStopped
+	if err := store.Close(); err != nil {
+		log.WithError(err).Error("Unable to close the store")
+	}
+
+	if err := statsdClient.Close(); err != nil {
+		log.WithError(err).Error("Unable to flush metrics")
+	}
+
+	logger.WithField("service", "search").Info("Server stopped")
+	if serveErr != nil {
+		os.Exit(1)
+	}
+}
+
+func cacheOptions(c config.Cache) cache.Options {
+	return cache.Options{
+		MaxEntries:  c.MaxEntries,
+		MaxBytes:    c.MaxBytes,
+		TTL:         time.Duration(c.TTL),
+		NegativeTTL: time.Duration(c.NegativeTTL),
+	}
+}
+
+// rateLimitTiers assigns every client listed in a tier to that tier
+func rateLimitTiers(c config.RateLimit) *ratelimit.Tiers {
+	limits := make(map[string]ratelimit.Limit)
+	clients := make(map[string]string)
+	for name, tier := range c.Tiers {
+		limits[name] = ratelimit.Limit{Burst: tier.Burst, Refill: tier.Refill, Daily: tier.Daily, Monthly: tier.Monthly}
 
-	logger.WithField("service", "search").Infof("Starting server, listening on %s", address)
-	log.WithField("service", "search").Fatal(http.ListenAndServe(address, http.DefaultServeMux))
+		for _, subject := range strings.Fields(tier.Subjects) {
+			clients[ratelimit.SubjectClient(subject)] = name
+		}
+		for _, key := range strings.Fields(tier.APIKeys) {
+			clients[ratelimit.APIKeyClient(key)] = name
+		}
+		for _, ip := range strings.Fields(tier.IPs) {
+			clients[ratelimit.IPClient(ip)] = name
+		}
+	}
+
+	return ratelimit.NewTiers(limits, clients)
+}
+
+// refreshKeys reads the key files again whenever the JWKS file changes so
+// that signing keys can be rotated without a restart
+func refreshKeys(keys *auth.KeySet, interval time.Duration) {
+	for range time.Tick(interval) {
+		if !keys.Changed() {
+			continue
+		}
+
+		if err := keys.Reload(); err != nil {
+			log.WithError(err).Error("Unable to reload the auth keys, keeping the current keys")
+			continue
+		}
+
+		log.Info("Reloaded the auth keys")
+	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/data/migrations"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrateTimeout bounds how long applying or reverting migrations may take,
+// including waiting for another instance to release the migrations lock
+const migrateTimeout = 5 * time.Minute
+
+const migrateUsage = `usage: search migrate [command]
+
+commands:
+  up            apply every pending migration (default)
+  down VERSION  revert migrations until the schema is at VERSION
+  status        print the current and latest schema versions`
+
+// migrate runs the migrate subcommand with args
+func migrate(migrator *migrations.Migrator, args []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch {
+	case command == "up" && len(args) <= 1:
+		applied, err := migrator.Up(ctx)
+		report("Applied", applied)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case command == "down" && len(args) == 2:
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal("VERSION must be a number")
+		}
+
+		reverted, err := migrator.Down(ctx, target)
+		report("Reverted", reverted)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case command == "status" && len(args) == 1:
+		current, err := migrator.Version(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("current version: %d\nlatest version: %d\n", current, migrator.Latest())
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
+
+func report(action string, applied []migrations.Migration) {
+	for _, m := range applied {
+		fmt.Printf("%s migration %d: %s\n", action, m.Version, m.Name)
+	}
+}
@@ -0,0 +1,331 @@
+// Package ratelimit limits the rate of requests of each client with token
+// buckets. A bucket holds up to Burst tokens and is refilled at Refill tokens
+// a second, every request takes a token and is refused when the bucket is
+// empty. Clients may also be given a quota of requests for each calendar day
+// and month in UTC, requests are refused once a quota is used up until the
+// period ends.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultTier is the tier of clients which are not assigned one
+const DefaultTier = "default"
+
+// sweepInterval is how often the Memory backend forgets full buckets
+const sweepInterval = time.Minute
+
+// Limit is the size and refill rate of a bucket along with the quotas of a
+// client, a Burst of 0 does not limit the rate and a quota of 0 does not
+// limit the requests in its period
+type Limit struct {
+	// Burst is the number of requests a client may make at once
+	Burst int
+	// Refill is the number of requests a second a client may sustain, it
+	// must be positive when Burst is
+	Refill float64
+	// Daily and Monthly are the number of requests a client may make in each
+	// calendar day and month in UTC
+	Daily   int
+	Monthly int
+}
+
+// Unlimited returns true when requests are not limited
+func (l Limit) Unlimited() bool {
+	return l.Burst <= 0 && l.Daily <= 0 && l.Monthly <= 0
+}
+
+// Quota returns true when the requests of a client are counted
+func (l Limit) Quota() bool {
+	return l.Daily > 0 || l.Monthly > 0
+}
+
+// Result describes the bucket and quota of a client after taking a token
+type Result struct {
+	Allowed bool
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+	// RetryAfter is how long until a request would be allowed, it is zero
+	// when the request was allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// QuotaExceeded is set when the request was refused because a quota is
+	// used up rather than because the bucket is empty
+	QuotaExceeded bool
+	// QuotaLimit, QuotaRemaining and QuotaReset describe the quota with the
+	// fewest requests remaining and how long until its period ends, they
+	// are zero when the client has no quota
+	QuotaLimit     int
+	QuotaRemaining int
+	QuotaReset     time.Duration
+}
+
+// Backend holds the bucket of every client, a backend shared by every
+// instance of the service limits clients across the cluster
+type Backend interface {
+	// Take takes a token from the bucket of key, creating a full bucket
+	// when key has not been seen
+	Take(key string, limit Limit) (Result, error)
+}
+
+// SubjectClient returns the key of a client identified by its token
+func SubjectClient(subject string) string {
+	return "subject:" + subject
+}
+
+// APIKeyClient returns the key of a client identified by its API key
+func APIKeyClient(key string) string {
+	return "key:" + key
+}
+
+// IPClient returns the key of a client identified by its IP address
+func IPClient(ip string) string {
+	return "ip:" + ip
+}
+
+// Tiers assigns each client a Limit
+type Tiers struct {
+	limits  map[string]Limit
+	clients map[string]string
+}
+
+// Limit returns the tier and limit of client, clients which are not assigned
+// a tier, or whose tier does not exist, get the default tier
+func (t *Tiers) Limit(client string) (tier string, limit Limit) {
+	tier, ok := t.clients[client]
+	if _, exists := t.limits[tier]; !ok || !exists {
+		tier = DefaultTier
+	}
+
+	return tier, t.limits[tier]
+}
+
+// Assigned returns true when client has been assigned a tier
+func (t *Tiers) Assigned(client string) bool {
+	_, ok := t.clients[client]
+	return ok
+}
+
+// NewTiers returns Tiers with limits keyed by tier name and clients mapping
+// a client key to its tier
+func NewTiers(limits map[string]Limit, clients map[string]string) *Tiers {
+	return &Tiers{limits: limits, clients: clients}
+}
+
+// Memory is a Backend which keeps buckets and quota usage in process, it
+// limits each instance of the service separately
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	usage   map[string]*usage
+	swept   time.Time
+	now     func() time.Time
+}
+
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+// usage counts the requests of a client in the current day and month
+type usage struct {
+	day     time.Time
+	month   time.Time
+	daily   int
+	monthly int
+}
+
+// Take implements Backend, it never returns an error. A request refused by
+// a quota does not take a token and a request refused by the bucket does not
+// count against the quotas.
+func (m *Memory) Take(key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.swept) >= sweepInterval {
+		m.sweep(now)
+	}
+
+	var u *usage
+	if limit.Quota() {
+		u = m.usage[key]
+		if u == nil {
+			u = &usage{}
+			m.usage[key] = u
+		}
+
+		u.roll(now)
+		if r := u.check(limit, now); !r.Allowed {
+			return r, nil
+		}
+	}
+
+	r := Result{Allowed: true}
+	if limit.Burst > 0 {
+		b, ok := m.buckets[key]
+		if !ok {
+			b = &bucket{limit: limit, tokens: float64(limit.Burst), updated: now}
+			m.buckets[key] = b
+		}
+
+		r = b.take(limit, now)
+	}
+
+	if u != nil {
+		if r.Allowed {
+			u.daily++
+			u.monthly++
+		}
+
+		u.describe(&r, limit, now)
+	}
+
+	return r, nil
+}
+
+// Len returns the number of buckets held
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.buckets)
+}
+
+// sweep forgets buckets which have refilled, a new full bucket is created
+// when the client returns so nothing is lost, and usage from past months
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if b.fill(now) >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+
+	month := startOfMonth(now)
+	for key, u := range m.usage {
+		if u.month.Before(month) {
+			delete(m.usage, key)
+		}
+	}
+
+	m.swept = now
+}
+
+// roll starts new counts when the day or month of now has begun
+func (u *usage) roll(now time.Time) {
+	if day := startOfDay(now); !u.day.Equal(day) {
+		u.day = day
+		u.daily = 0
+	}
+
+	if month := startOfMonth(now); !u.month.Equal(month) {
+		u.month = month
+		u.monthly = 0
+	}
+}
+
+// check refuses the request when a quota is used up, the client must wait
+// until every used up quota has a new period
+func (u *usage) check(limit Limit, now time.Time) Result {
+	r := Result{Allowed: true}
+	if limit.Daily > 0 && u.daily >= limit.Daily {
+		r.Allowed = false
+		r.RetryAfter = u.day.AddDate(0, 0, 1).Sub(now)
+	}
+
+	if limit.Monthly > 0 && u.monthly >= limit.Monthly {
+		r.Allowed = false
+		r.RetryAfter = u.month.AddDate(0, 1, 0).Sub(now)
+	}
+
+	if !r.Allowed {
+		r.QuotaExceeded = true
+		u.describe(&r, limit, now)
+	}
+
+	return r
+}
+
+// describe sets the quota of r to the quota with the fewest requests
+// remaining
+func (u *usage) describe(r *Result, limit Limit, now time.Time) {
+	r.QuotaLimit = 0
+	if limit.Monthly > 0 {
+		r.QuotaLimit = limit.Monthly
+		r.QuotaRemaining = remaining(limit.Monthly, u.monthly)
+		r.QuotaReset = u.month.AddDate(0, 1, 0).Sub(now)
+	}
+
+	if limit.Daily > 0 && (r.QuotaLimit == 0 || remaining(limit.Daily, u.daily) < r.QuotaRemaining) {
+		r.QuotaLimit = limit.Daily
+		r.QuotaRemaining = remaining(limit.Daily, u.daily)
+		r.QuotaReset = u.day.AddDate(0, 0, 1).Sub(now)
+	}
+}
+
+func remaining(quota, used int) int {
+	if used >= quota {
+		return 0
+	}
+
+	return quota - used
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fill returns the tokens in the bucket at now
+func (b *bucket) fill(now time.Time) float64 {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed <= 0 {
+		return b.tokens
+	}
+
+	return math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Refill)
+}
+
+func (b *bucket) take(limit Limit, now time.Time) Result {
+	// a changed limit applies from now, the bucket keeps its tokens up to
+	// the new burst
+	b.tokens = b.fill(now)
+	b.limit = limit
+	b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	b.updated = now
+
+	r := Result{}
+	if b.tokens >= 1 {
+		b.tokens--
+		r.Allowed = true
+	} else {
+		r.RetryAfter = seconds((1 - b.tokens) / limit.Refill)
+	}
+
+	r.Remaining = int(b.tokens)
+	r.Reset = seconds((float64(limit.Burst) - b.tokens) / limit.Refill)
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// NewMemory returns an empty in process Backend
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		usage:   make(map[string]*usage),
+		now:     time.Now,
+	}
+}
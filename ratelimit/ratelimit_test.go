@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestABucketAllowsABurstThenRefills(t *testing.T) {
+	m, c := newMemory()
+	limit := Limit{Burst: 3, Refill: 2}
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		r, err := m.Take("ip:10.0.0.1", limit)
+		assert.Nil(t, err)
+		assert.True(t, r.Allowed)
+		assert.Equal(t, remaining, r.Remaining)
+	}
+
+	r, _ := m.Take("ip:10.0.0.1", limit)
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 500*time.Millisecond, r.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, r.Reset)
+
+	// other clients have their own bucket
+	r, _ = m.Take("ip:10.0.0.2", limit)
+	assert.True(t, r.Allowed)
+
+	c.now = c.now.Add(500 * time.Millisecond)
+	r, _ = m.Take("ip:10.0.0.1", limit)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 0, r.Remaining)
+}
+
+func TestAReducedBurstAppliesToExistingBuckets(t *testing.T) {
+	m, _ := newMemory()
+
+	m.Take("subject:nic", Limit{Burst: 10, Refill: 1})
+	r, _ := m.Take("subject:nic", Limit{Burst: 2, Refill: 1})
+
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 1, r.Remaining)
+}
+
+func TestFullBucketsAreForgotten(t *testing.T) {
+	m, c := newMemory()
+	limit := Limit{Burst: 2, Refill: 1}
+
+	m.Take("ip:10.0.0.1", limit)
+	m.Take("ip:10.0.0.2", limit)
+	m.Take("ip:10.0.0.2", limit)
+	assert.Equal(t, 2, m.Len())
+
+	c.now = c.now.Add(sweepInterval)
+	m.Take("ip:10.0.0.3", limit)
+
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestQuotasRefuseRequestsUntilThePeriodEnds(t *testing.T) {
+	m, c := newMemory()
+	c.now = time.Date(2017, time.July, 31, 22, 0, 0, 0, time.UTC)
+	limit := Limit{Daily: 2, Monthly: 3}
+
+	r, _ := m.Take("key:partner", limit)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 2, r.QuotaLimit)
+	assert.Equal(t, 1, r.QuotaRemaining)
+	assert.Equal(t, 2*time.Hour, r.QuotaReset)
+
+	m.Take("key:partner", limit)
+	r, _ = m.Take("key:partner", limit)
+	assert.False(t, r.Allowed)
+	assert.True(t, r.QuotaExceeded)
+	assert.Equal(t, 2*time.Hour, r.RetryAfter)
+
+	// a new day and month resets both quotas
+	c.now = c.now.Add(2 * time.Hour)
+	r, _ = m.Take("key:partner", limit)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 1, r.QuotaRemaining)
+}
+
+func TestTheMonthlyQuotaOutlastsTheDay(t *testing.T) {
+	m, c := newMemory()
+	c.now = time.Date(2017, time.July, 30, 12, 0, 0, 0, time.UTC)
+	limit := Limit{Daily: 5, Monthly: 1}
+
+	m.Take("key:partner", limit)
+	c.now = c.now.Add(24 * time.Hour)
+	r, _ := m.Take("key:partner", limit)
+
+	assert.False(t, r.Allowed)
+	assert.Equal(t, 1, r.QuotaLimit)
+	assert.Equal(t, 0, r.QuotaRemaining)
+	assert.Equal(t, 12*time.Hour, r.RetryAfter)
+}
+
+func TestRequestsRefusedByTheBucketDoNotCountAgainstTheQuota(t *testing.T) {
+	m, c := newMemory()
+	limit := Limit{Burst: 1, Refill: 1, Daily: 10}
+
+	m.Take("ip:10.0.0.1", limit)
+	r, _ := m.Take("ip:10.0.0.1", limit)
+	assert.False(t, r.Allowed)
+	assert.False(t, r.QuotaExceeded)
+	assert.Equal(t, 9, r.QuotaRemaining)
+
+	c.now = c.now.Add(time.Second)
+	r, _ = m.Take("ip:10.0.0.1", limit)
+	assert.True(t, r.Allowed)
+	assert.Equal(t, 8, r.QuotaRemaining)
+}
+
+func TestClientsWithoutATierGetTheDefault(t *testing.T) {
+	tiers := NewTiers(
+		map[string]Limit{DefaultTier: {Burst: 5, Refill: 1}, "partner": {Burst: 100, Refill: 50}},
+		map[string]string{SubjectClient("acme"): "partner", IPClient("10.0.0.1"): "removed"},
+	)
+
+	tier, limit := tiers.Limit(SubjectClient("acme"))
+	assert.Equal(t, "partner", tier)
+	assert.Equal(t, 100, limit.Burst)
+
+	for _, client := range []string{SubjectClient("nic"), IPClient("10.0.0.1")} {
+		tier, limit = tiers.Limit(client)
+		assert.Equal(t, DefaultTier, tier)
+		assert.Equal(t, 5, limit.Burst)
+	}
+}
+
+func newMemory() (*Memory, *clock) {
+	c := &clock{now: time.Unix(1500000000, 0)}
+	m := NewMemory()
+	m.now = c.Now
+	m.swept = c.now
+
+	return m, c
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// reloader re-reads the configuration and applies the settings which can be
+// changed while the service is running, a configuration which is invalid or
+// can not be applied is rejected and the current settings are kept
+type reloader struct {
+	args   []string
+	getenv func(string) string
+	statsd *statsd.Client
+	// appliers change the live settings of a component, they are called in
+	// order and must be safe to call concurrently with requests
+	appliers []func(c config.Config) error
+
+	mu      sync.Mutex
+	current config.Config
+}
+
+// Config returns the configuration currently in effect
+func (r *reloader) Config() config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+// Reload loads and applies the configuration, if an applier fails the
+// settings already changed are restored
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, _, err := config.Load(r.args, r.getenv)
+	if err != nil {
+		r.statsd.Incr("config.reload.failed", nil, 1)
+		log.WithError(err).Error("Configuration rejected, keeping the current settings")
+		return err
+	}
+
+	for i, apply := range r.appliers {
+		if err := apply(next); err != nil {
+			for _, undo := range r.appliers[:i+1] {
+				undo(r.current)
+			}
+
+			r.statsd.Incr("config.reload.failed", nil, 1)
+			log.WithError(err).Error("Unable to apply the configuration, the previous settings were restored")
+			return err
+		}
+	}
+
+	for _, setting := range restartRequired(r.current, next) {
+		log.WithField("setting", setting).Warn("Changed setting will take effect after a restart")
+	}
+
+	r.current = next
+	r.statsd.Incr("config.reload.success", nil, 1)
+	log.Infof("Configuration reloaded:\n%s", next)
+
+	return nil
+}
+
+// reloadOnHangup reloads the configuration whenever the process receives
+// SIGHUP, errors have already been logged by Reload
+func (r *reloader) reloadOnHangup() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	go func() {
+		for range hangups {
+			r.Reload()
+		}
+	}()
+}
+
+// restartRequired returns the settings which differ between old and next
+// but are only read when the service starts
+func restartRequired(old, next config.Config) []string {
+	var changed []string
+	for setting, same := range map[string]bool{
+		"address": old.Address == next.Address,
+		"statsd":  old.Statsd == next.Statsd,
+		"store":   old.Store == next.Store,
+		"server":  old.Server == next.Server,
+		"admin":   old.Admin == next.Admin,
+		"auth":    sameKeys(old.Auth, next.Auth),
+	} {
+		if !same {
+			changed = append(changed, setting)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed
+}
+
+// sameKeys returns true when a and b verify tokens in the same way, the
+// policy and roles may differ as they are applied on reload
+func sameKeys(a, b config.Auth) bool {
+	a.Policy, a.Roles, b.Policy, b.Roles = nil, nil, nil, nil
+
+	return reflect.DeepEqual(a, b)
+}
+
+// newReloader creates a reloader which applies c with every applier, args
+// and getenv are the sources given to config.Load on each reload
+func newReloader(c config.Config, args []string, getenv func(string) string, statsd *statsd.Client, appliers ...func(c config.Config) error) (*reloader, error) {
+	for _, apply := range appliers {
+		if err := apply(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return &reloader{
+		args:     args,
+		getenv:   getenv,
+		statsd:   statsd,
+		appliers: appliers,
+		current:  c,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadAppliesTheNewConfiguration(t *testing.T) {
+	env := map[string]string{"MYSQL_CONNECTION": "root@tcp(mysql:3306)/kittens"}
+	var applied []string
+
+	r, err := newReloader(config.Default(), nil, getenv(env), nil, func(c config.Config) error {
+		applied = append(applied, c.LogLevel)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	env["SEARCH_LOG_LEVEL"] = "warning"
+	assert.Nil(t, r.Reload())
+
+	assert.Equal(t, []string{"debug", "warning"}, applied)
+	assert.Equal(t, "warning", r.Config().LogLevel)
+}
+
+func TestReloadKeepsTheCurrentConfigurationWhenTheNewOneIsInvalid(t *testing.T) {
+	env := map[string]string{"MYSQL_CONNECTION": "root@tcp(mysql:3306)/kittens"}
+	calls := 0
+
+	r, _ := newReloader(config.Default(), nil, getenv(env), nil, func(c config.Config) error {
+		calls++
+		return nil
+	})
+
+	env["SEARCH_CACHE_TTL"] = "-1s"
+	assert.NotNil(t, r.Reload())
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, config.Default().Cache, r.Config().Cache)
+}
+
+func TestReloadRestoresSettingsWhenAnApplierFails(t *testing.T) {
+	env := map[string]string{"MYSQL_CONNECTION": "root@tcp(mysql:3306)/kittens"}
+	level := ""
+
+	r, _ := newReloader(config.Default(), nil, getenv(env), nil,
+		func(c config.Config) error {
+			level = c.LogLevel
+			return nil
+		},
+		func(c config.Config) error {
+			if c.Search.Boosts != "" {
+				return errors.New("boosts can not be applied")
+			}
+			return nil
+		},
+	)
+
+	env["SEARCH_LOG_LEVEL"] = "error"
+	env["SEARCH_BOOSTS"] = "name:2"
+	assert.NotNil(t, r.Reload())
+
+	assert.Equal(t, "debug", level)
+	assert.Equal(t, "debug", r.Config().LogLevel)
+}
+
+func getenv(env map[string]string) func(string) string {
+	return func(name string) string {
+		return env[name]
+	}
+}
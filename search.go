@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/cache"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/index"
+	"github.com/building-microservices-with-go/chapter10-services-search/handlers"
+	log "github.com/sirupsen/logrus"
+)
+
+// newSearch returns the search handler, repeated searches are answered from
+// a cache which is emptied by every write and every reload of the index, and
+// the last good result of recent searches is served, marked as stale, while
+// the index is unavailable
+func newSearch(searchIndex *index.Index, c config.Cache, statsd *statsd.Client) (*handlers.Search, *cache.Cache, *cache.Snapshot) {
+	results := cache.New(searchIndex, cacheOptions(c), statsd)
+	snapshot := cache.NewSnapshot(c.SnapshotEntries, statsd)
+	results.SetSnapshot(snapshot)
+
+	return handlers.NewSearch(results, snapshot, statsd), results, snapshot
+}
+
+// maxIndexAge is how old the index may be before searches are refused, that
+// is once two reloads have been missed
+func maxIndexAge(c config.Search) time.Duration {
+	return 2*time.Duration(c.ReloadInterval) + time.Duration(c.LoadTimeout)
+}
+
+// reloadSearch rebuilds the index from the store and empties the cache of
+// results. The index refuses searches after a failed reload, or once it is
+// older than maxIndexAge, so that the snapshot is served rather than results
+// which may miss writes made through other instances.
+func reloadSearch(ctx context.Context, store data.Store, searchIndex *index.Index, results *cache.Cache, c config.Search) {
+	searchIndex.SetMaxAge(maxIndexAge(c))
+
+	if err := searchIndex.Reload(ctx, store); err != nil {
+		log.WithError(err).Error("Unable to reload the search index")
+	}
+	results.Invalidate()
+}
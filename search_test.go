@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/index"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchServesTheSnapshotWhileTheStoreIsUnavailable(t *testing.T) {
+	store := &data.MockStore{}
+	store.On("All").Return([]data.Kitten{{Id: "1", Name: "Felix", Weight: 12.3}, {Id: "2", Name: "Tom", Weight: 15}}, nil).Once()
+	store.On("All").Return([]data.Kitten(nil), data.ErrUnavailable)
+
+	cfg := config.Default()
+	searchIndex, err := index.Load(context.Background(), store)
+	assert.Nil(t, err)
+	search, results, _ := newSearch(searchIndex, cfg.Cache, nil)
+
+	rw := searchFor(search.Handle, "felix")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "", rw.Header().Get("X-Search-Degraded"))
+
+	reloadSearch(context.Background(), store, searchIndex, results, cfg.Search)
+
+	rw = searchFor(search.Handle, "felix")
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "stale", rw.Header().Get("X-Search-Degraded"))
+	assert.Contains(t, rw.Body.String(), "Felix")
+
+	rw = searchFor(search.Handle, "tom")
+	assert.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
+
+func searchFor(handler http.HandlerFunc, query string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"query": query})
+	rw := httptest.NewRecorder()
+	handler(rw, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+
+	return rw
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// serve runs server until the process receives SIGINT or SIGTERM, it then
+// stops accepting connections and waits up to timeout for the requests in
+// progress to complete
+func serve(server *http.Server, timeout time.Duration) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case s := <-signals:
+		log.WithField("service", "search").Infof("Received %s, shutting down", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return server.Shutdown(ctx)
+}
+
+func newServer(address string, handler http.Handler, c config.Server) *http.Server {
+	return &http.Server{
+		Addr:         address,
+		Handler:      handler,
+		ReadTimeout:  time.Duration(c.ReadTimeout),
+		WriteTimeout: time.Duration(c.WriteTimeout),
+		IdleTimeout:  time.Duration(c.IdleTimeout),
+	}
+}
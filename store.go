@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/building-microservices-with-go/chapter10-services-search/config"
+	"github.com/building-microservices-with-go/chapter10-services-search/data"
+	"github.com/building-microservices-with-go/chapter10-services-search/data/resilient"
+)
+
+// kittenStore is the datastore which the service searches and writes to, it
+// is closed when the service shuts down
+type kittenStore interface {
+	data.Store
+	data.Writer
+	io.Closer
+}
+
+// resilientStore calls the mysql store through timeouts, retries and a
+// circuit breaker
+type resilientStore struct {
+	*resilient.Store
+	io.Closer
+}
+
+// openStore opens the store chosen by the config, either mysql or file for
+// an embedded store which needs no database
+func openStore(c config.Store, statsd *statsd.Client) (kittenStore, error) {
+	if c.Kind == "file" {
+		policy, err := data.ParseSyncPolicy(c.Fsync)
+		if err != nil {
+			return nil, err
+		}
+
+		options := data.DefaultFileStoreOptions()
+		options.Sync = policy
+		options.SyncInterval = time.Duration(c.FsyncInterval)
+
+		return data.OpenFileStore(c.DataDir, options)
+	}
+
+	store, err := data.NewMySQLStore(c.MySQLConnection)
+	if err != nil {
+		return nil, err
+	}
+
+	// every instance brings the schema up to date before serving, the
+	// migrations lock ensures only one of them applies each migration
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	if err := store.Migrate(ctx); err != nil {
+		return nil, err
+	}
+
+	options := resilient.Options{
+		Timeout:          time.Duration(c.Timeout),
+		ImportTimeout:    time.Duration(c.ImportTimeout),
+		Retries:          c.Retries,
+		Backoff:          time.Duration(c.Backoff),
+		MaxBackoff:       time.Duration(c.MaxBackoff),
+		FailureThreshold: c.BreakerThreshold,
+		OpenTimeout:      time.Duration(c.BreakerTimeout),
+	}
+
+	return resilientStore{Store: resilient.New(store, options, statsd), Closer: store}, nil
+}
@@ -1,5 +1,5 @@
 USE kittens;
 CREATE TABLE Kittens (Id varchar(50), Name varchar(200), Weight int);
 
-INSERT INTO Kittens VALUES ("abc123", "Fat Freddies Cat", 100);
-INSERT INTO Kittens VALUES ("adef124", "Garfield", 120);
+INSERT INTO Kittens (Id, Name, Weight) VALUES ("abc123", "Fat Freddies Cat", 100);
+INSERT INTO Kittens (Id, Name, Weight) VALUES ("adef124", "Garfield", 120);
\ No newline at end of file